@@ -1363,6 +1363,22 @@ var commandsJSON = `{
     "since": "1.0.0",
     "group": "server"
   },
+  "DEBUG OBJECT": {
+    "summary": "Shows the bounds of an object and its placement in the spatial index",
+    "complexity": "O(log N) where N is the number of objects in the collection",
+    "arguments":[
+      {
+        "name": "key",
+        "type": "string"
+      },
+      {
+        "name": "id",
+        "type": "string"
+      }
+    ],
+    "since": "1.10.0",
+    "group": "server"
+  },
   "READONLY": {
     "summary": "Turns on or off readonly mode",
     "complexity": "O(1)",
@@ -1392,6 +1408,13 @@ var commandsJSON = `{
       {
         "name": "port",
         "type": "integer"
+      },
+      {
+        "command": "FROM",
+        "name": ["offset"],
+        "type": ["integer"],
+        "optional": true,
+        "multiple": false
       }
     ],
     "since": "1.0.0",
@@ -1941,9 +1964,37 @@ var commandsJSON = `{
     "since": "1.10.0",
     "group": "scripting"
   },
+  "EVALNAME":{
+    "summary": "Evaluates a Lua script cached on the server by the name it was loaded with",
+    "complexity": "Depends on the evaluated script",
+    "arguments": [
+      {
+        "name": "name",
+        "type": "string"
+      },
+      {
+        "name": "numkeys",
+        "type": "integer"
+      },
+      {
+        "name": "key",
+        "type": "string",
+        "optional": true,
+        "multiple": true
+      },
+      {
+        "name": "arg",
+        "type": "string",
+        "optional": true,
+        "multiple": true
+      }
+    ],
+    "since": "1.10.0",
+    "group": "scripting"
+  },
   "SCRIPT EXISTS":{
     "summary": "Returns information about the existence of the scripts in server cache",
-    "complexity": "O(N) where N is the number of provided sha1 arguments",
+    "complexity": "O(N) where N is the number of provided sha1 or name arguments",
     "arguments": [
       {
         "name": "sha1",
@@ -1961,6 +2012,12 @@ var commandsJSON = `{
       {
         "name": "script",
         "type": "string"
+      },
+      {
+        "command": "NAME",
+        "name": "name",
+        "type": "string",
+        "optional": true
       }
     ],
     "since": "1.10.0",
@@ -1972,6 +2029,12 @@ var commandsJSON = `{
     "since": "1.10.0",
     "group": "scripting"
   },
+  "SCRIPT KILL":{
+    "summary": "Kills the currently executing Lua scripts that have not performed any writes",
+    "complexity": "O(N) where N is the number of running scripts",
+    "since": "1.10.0",
+    "group": "scripting"
+  },
   "TEST":{
     "summary": "Performs spatial test",
     "complexity": "One test per command, complexity depends on the test",
@@ -239,6 +239,51 @@ func (c *Collection) Get(id string) (
 	return item.obj, c.fieldValues.get(item.fieldValuesSlot), true
 }
 
+// Placement returns the bounding rect of an object along with the rects of
+// the R-tree nodes on the path from the root down to the node holding the
+// object. The ok return value is false when the object does not exist or
+// is not spatially indexed.
+func (c *Collection) Placement(id string) (
+	rect geometry.Rect, path []geometry.Rect, ok bool,
+) {
+	itemV, ok := c.items.Get(id)
+	if !ok {
+		return rect, nil, false
+	}
+	item := itemV.(*itemT)
+	if !objIsSpatial(item.obj) || item.obj.Empty() {
+		return rect, nil, false
+	}
+	rect = item.obj.Rect()
+	path, ok = c.placementPath(nil, item, rect, nil)
+	return rect, path, ok
+}
+
+func (c *Collection) placementPath(
+	parent interface{}, item *itemT, rect geometry.Rect, path []geometry.Rect,
+) ([]geometry.Rect, bool) {
+	for _, child := range c.index.Children(parent, nil) {
+		if child.Item {
+			if child.Data == item {
+				return path, true
+			}
+			continue
+		}
+		node := geometry.Rect{
+			Min: geometry.Point{X: child.Min[0], Y: child.Min[1]},
+			Max: geometry.Point{X: child.Max[0], Y: child.Max[1]},
+		}
+		if !node.ContainsRect(rect) {
+			continue
+		}
+		if path, ok := c.placementPath(child.Data, item, rect,
+			append(path, node)); ok {
+			return path, true
+		}
+	}
+	return nil, false
+}
+
 // SetField set a field value for an object and returns that object.
 // If the object does not exist then the 'ok' return value will be false.
 func (c *Collection) SetField(id, field string, value float64) (
@@ -2,9 +2,13 @@ package collection
 
 import (
 	"fmt"
+	"io/ioutil"
 	"math/rand"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -803,3 +807,79 @@ func benchmarkScan(t *testing.B, nFields int) {
 		})
 	}
 }
+
+func TestCollectionLoadCorruptIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tile38-collection")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := New()
+	for i := 0; i < 5; i++ {
+		c.Set(strconv.Itoa(i), PO(float64(i), float64(i)), nil, nil)
+	}
+	if err := c.Save(dir, 1); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, New().Load(dir, 1, nil) == nil)
+
+	// The single leaf of the tree starts after the snapshot id (8), height
+	// (8), count (8), gotTree (1), root rect (32), count (1), gotChildren
+	// (1) and the first item rect (32). Point its item past the end.
+	indexFile := filepath.Join(dir, "indexTree")
+	data, err := ioutil.ReadFile(indexFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt := append([]byte{}, data...)
+	copy(corrupt[91:], []byte{0xFF, 0xFF, 0xFF, 0xFF})
+	if err := ioutil.WriteFile(indexFile, corrupt, 0600); err != nil {
+		t.Fatal(err)
+	}
+	err = New().Load(dir, 1, nil)
+	expect(t, err != nil)
+	expect(t, strings.Contains(err.Error(), "out of range"))
+
+	// A node count beyond the node capacity must not crash the process.
+	corrupt = append([]byte{}, data...)
+	corrupt[8+8+8+1+32] = 0xFF
+	corrupt = append(corrupt, make([]byte, 64*36)...)
+	if err := ioutil.WriteFile(indexFile, corrupt, 0600); err != nil {
+		t.Fatal(err)
+	}
+	err = New().Load(dir, 1, nil)
+	expect(t, err != nil)
+	expect(t, strings.Contains(err.Error(), "corrupt indexTree"))
+}
+
+func TestCollectionPlacement(t *testing.T) {
+	c := New()
+	for i := 0; i < 10000; i++ {
+		id := strconv.FormatInt(int64(i), 10)
+		c.Set(id, PO(rand.Float64()*360-180, rand.Float64()*180-90), nil, nil)
+	}
+	c.Set("str", String("hello"), nil, nil)
+	var depth int
+	for i := 0; i < 10000; i++ {
+		id := strconv.FormatInt(int64(i), 10)
+		obj, _, _ := c.Get(id)
+		rect, path, ok := c.Placement(id)
+		expect(t, ok)
+		expect(t, rect == obj.Rect())
+		expect(t, len(path) > 1)
+		if depth == 0 {
+			depth = len(path)
+		}
+		// all leaves of an R-tree are at the same depth
+		expect(t, len(path) == depth)
+		expect(t, path[0] == bounds(c))
+		for _, node := range path {
+			expect(t, node.ContainsRect(rect))
+		}
+	}
+	_, _, ok := c.Placement("str")
+	expect(t, !ok)
+	_, _, ok = c.Placement("missing")
+	expect(t, !ok)
+}
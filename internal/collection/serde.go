@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
@@ -754,12 +755,17 @@ func (c * Collection) loadIndexTree(treeFile string, itemList []*itemT, snapshot
 		if err = binary.Read(r, binary.BigEndian, &itemNum); err != nil {
 			return
 		}
+		if int(itemNum) >= len(itemList) {
+			err = fmt.Errorf("item %d out of range, only %d items loaded", itemNum, len(itemList))
+			return
+		}
 		return itemList[itemNum], obuf,nil
 	}
 
 	c.index = geoindex.Wrap(&rbang.RTree{})
-	if err = c.index.Load(br, itemLoader); err != nil {
-		log.Errorf("Failed to load valuesTree")
+	if err = loadIndex(c.index, br, itemLoader); err != nil {
+		log.Errorf("Failed to load indexTree: %v", err)
+		return
 	}
 	if err = verifySnapshotId(br, snapshotId); err != nil {
 		return
@@ -769,6 +775,21 @@ func (c * Collection) loadIndexTree(treeFile string, itemList []*itemT, snapshot
 }
 
 // Helper functions
+
+// loadIndex loads the index, turning a panic on a corrupt file into an error
+func loadIndex(
+	index *geoindex.Index,
+	r io.Reader,
+	loadValue func (r io.Reader, obuf []byte) (interface{}, []byte, error),
+) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("corrupt indexTree: %v", v)
+		}
+	}()
+	return index.Load(r, loadValue)
+}
+
 func stringAsBytes(s string) []byte {
 	var b []byte
 	bHdr := (*reflect.SliceHeader)(unsafe.Pointer(&b))
@@ -16,8 +16,10 @@ import (
 )
 
 const (
-	defaultKeepAlive     = 300 // seconds
-	defaultProtectedMode = "yes"
+	defaultKeepAlive      = 300 // seconds
+	defaultProtectedMode  = "yes"
+	defaultMemStatsPoller = "yes"
+	defaultSnapshotLegacy = "no"
 )
 
 // Config keys
@@ -34,9 +36,16 @@ const (
 	MaxMemory     = "maxmemory"
 	AutoGC        = "autogc"
 	KeepAlive     = "keepalive"
+	LuaPoolInit   = "lua-pool-init"
+	LuaPoolMax    = "lua-pool-max"
+
+	DefaultScanTimeout    = "default-scan-timeout"
+	ScriptCommandDenylist = "script-command-denylist"
+	MemStatsPoller        = "memstats-poller"
+	SnapshotLegacy        = "snapshot-legacy"
 )
 
-var validProperties = []string{RequirePass, LeaderAuth, ProtectedMode, MaxMemory, AutoGC, KeepAlive}
+var validProperties = []string{RequirePass, LeaderAuth, ProtectedMode, MaxMemory, AutoGC, KeepAlive, LuaPoolInit, LuaPoolMax, DefaultScanTimeout, ScriptCommandDenylist, MemStatsPoller, SnapshotLegacy}
 
 // Config is a tile38 config
 type Config struct {
@@ -63,6 +72,22 @@ type Config struct {
 	_autoGC         uint64
 	_keepAliveP     string
 	_keepAlive      int64
+	_luaPoolInitP   string
+	_luaPoolInit    int64
+	_luaPoolMaxP    string
+	_luaPoolMax     int64
+
+	_defaultScanTimeoutP string
+	_defaultScanTimeout  time.Duration
+
+	_scriptCommandDenylistP string
+	_scriptCommandDenylist  map[string]bool
+
+	_memStatsPollerP string
+	_memStatsPoller  string
+
+	_snapshotLegacyP string
+	_snapshotLegacy  string
 }
 
 func loadConfig(path string) (*Config, error) {
@@ -91,6 +116,16 @@ func loadConfig(path string) (*Config, error) {
 		_maxMemoryP:     gjson.Get(json, MaxMemory).String(),
 		_autoGCP:        gjson.Get(json, AutoGC).String(),
 		_keepAliveP:     gjson.Get(json, KeepAlive).String(),
+		_luaPoolInitP:   gjson.Get(json, LuaPoolInit).String(),
+		_luaPoolMaxP:    gjson.Get(json, LuaPoolMax).String(),
+
+		_defaultScanTimeoutP: gjson.Get(json, DefaultScanTimeout).String(),
+
+		_scriptCommandDenylistP: gjson.Get(json, ScriptCommandDenylist).String(),
+
+		_memStatsPollerP: gjson.Get(json, MemStatsPoller).String(),
+
+		_snapshotLegacyP: gjson.Get(json, SnapshotLegacy).String(),
 	}
 	// load properties
 	if err := config.setProperty(RequirePass, config._requirePassP, true); err != nil {
@@ -111,6 +146,24 @@ func loadConfig(path string) (*Config, error) {
 	if err := config.setProperty(KeepAlive, config._keepAliveP, true); err != nil {
 		return nil, err
 	}
+	if err := config.setProperty(LuaPoolInit, config._luaPoolInitP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(LuaPoolMax, config._luaPoolMaxP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(DefaultScanTimeout, config._defaultScanTimeoutP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(ScriptCommandDenylist, config._scriptCommandDenylistP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(MemStatsPoller, config._memStatsPollerP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(SnapshotLegacy, config._snapshotLegacyP, true); err != nil {
+		return nil, err
+	}
 	config.write(false)
 	return config, nil
 }
@@ -139,6 +192,31 @@ func (config *Config) write(writeProperties bool) {
 		} else {
 			config._keepAliveP = strconv.FormatUint(uint64(config._keepAlive), 10)
 		}
+		if config._luaPoolInit == iniLuaPoolSize {
+			config._luaPoolInitP = ""
+		} else {
+			config._luaPoolInitP = strconv.FormatInt(config._luaPoolInit, 10)
+		}
+		if config._luaPoolMax == maxLuaPoolSize {
+			config._luaPoolMaxP = ""
+		} else {
+			config._luaPoolMaxP = strconv.FormatInt(config._luaPoolMax, 10)
+		}
+		if config._defaultScanTimeout == 0 {
+			config._defaultScanTimeoutP = ""
+		} else {
+			config._defaultScanTimeoutP = formatSeconds(config._defaultScanTimeout)
+		}
+		if config._memStatsPoller == defaultMemStatsPoller {
+			config._memStatsPollerP = ""
+		} else {
+			config._memStatsPollerP = config._memStatsPoller
+		}
+		if config._snapshotLegacy == defaultSnapshotLegacy {
+			config._snapshotLegacyP = ""
+		} else {
+			config._snapshotLegacyP = config._snapshotLegacy
+		}
 	}
 
 	m := make(map[string]interface{})
@@ -178,6 +256,24 @@ func (config *Config) write(writeProperties bool) {
 	if config._keepAliveP != "" {
 		m[KeepAlive] = config._keepAliveP
 	}
+	if config._luaPoolInitP != "" {
+		m[LuaPoolInit] = config._luaPoolInitP
+	}
+	if config._luaPoolMaxP != "" {
+		m[LuaPoolMax] = config._luaPoolMaxP
+	}
+	if config._defaultScanTimeoutP != "" {
+		m[DefaultScanTimeout] = config._defaultScanTimeoutP
+	}
+	if config._scriptCommandDenylistP != "" {
+		m[ScriptCommandDenylist] = config._scriptCommandDenylistP
+	}
+	if config._memStatsPollerP != "" {
+		m[MemStatsPoller] = config._memStatsPollerP
+	}
+	if config._snapshotLegacyP != "" {
+		m[SnapshotLegacy] = config._snapshotLegacyP
+	}
 	data, err := json.MarshalIndent(m, "", "\t")
 	if err != nil {
 		panic(err)
@@ -286,6 +382,83 @@ func (config *Config) setProperty(name, value string, fromLoad bool) error {
 				config._keepAlive = int64(keepalive)
 			}
 		}
+	case LuaPoolInit:
+		n := uint64(iniLuaPoolSize)
+		var err error
+		if value != "" {
+			n, err = strconv.ParseUint(value, 10, 64)
+		}
+		// while loading, lua-pool-max is not set yet and is checked
+		// against lua-pool-init instead
+		if err != nil || n > maxLuaPoolInit ||
+			(!fromLoad && int64(n) > config._luaPoolMax) {
+			invalid = true
+		} else {
+			config._luaPoolInit = int64(n)
+		}
+	case LuaPoolMax:
+		if value == "" {
+			config._luaPoolMax = maxLuaPoolSize
+		} else {
+			n, err := strconv.ParseUint(value, 10, 64)
+			if err != nil || n < 1 || n > maxLuaPoolMax ||
+				int64(n) < config._luaPoolInit {
+				invalid = true
+			} else {
+				config._luaPoolMax = int64(n)
+			}
+		}
+	case DefaultScanTimeout:
+		if value == "" {
+			config._defaultScanTimeout = 0
+		} else {
+			secs, err := strconv.ParseFloat(value, 64)
+			if err != nil || secs < 0 {
+				invalid = true
+			} else {
+				config._defaultScanTimeout = time.Duration(secs * float64(time.Second))
+			}
+		}
+	case ScriptCommandDenylist:
+		// a comma or space separated list of commands
+		denylist := make(map[string]bool)
+		var cmds []string
+		for _, cmd := range strings.FieldsFunc(strings.ToLower(value), func(r rune) bool {
+			return r == ',' || r == ' '
+		}) {
+			if !denylist[cmd] {
+				denylist[cmd] = true
+				cmds = append(cmds, cmd)
+			}
+		}
+		config._scriptCommandDenylist = denylist
+		config._scriptCommandDenylistP = strings.Join(cmds, ",")
+	case MemStatsPoller:
+		switch strings.ToLower(value) {
+		case "":
+			if fromLoad {
+				config._memStatsPoller = defaultMemStatsPoller
+			} else {
+				invalid = true
+			}
+		case "yes", "no":
+			config._memStatsPoller = strings.ToLower(value)
+		default:
+			invalid = true
+		}
+	case SnapshotLegacy:
+		switch strings.ToLower(value) {
+		case "":
+			if fromLoad {
+				config._snapshotLegacy = defaultSnapshotLegacy
+			} else {
+				invalid = true
+			}
+		case "yes", "no":
+			config._snapshotLegacy = strings.ToLower(value)
+		default:
+			invalid = true
+		}
 	}
 
 	if invalid {
@@ -323,9 +496,26 @@ func (config *Config) getProperty(name string) string {
 		return formatMemSize(config._maxMemory)
 	case KeepAlive:
 		return strconv.FormatUint(uint64(config._keepAlive), 10)
+	case LuaPoolInit:
+		return strconv.FormatInt(config._luaPoolInit, 10)
+	case LuaPoolMax:
+		return strconv.FormatInt(config._luaPoolMax, 10)
+	case DefaultScanTimeout:
+		return formatSeconds(config._defaultScanTimeout)
+	case ScriptCommandDenylist:
+		return config._scriptCommandDenylistP
+	case MemStatsPoller:
+		return config._memStatsPoller
+	case SnapshotLegacy:
+		return config._snapshotLegacy
 	}
 }
 
+// formatSeconds formats a duration as a number of seconds
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+}
+
 func (s *Server) cmdConfigGet(msg *Message) (res resp.Value, err error) {
 	start := time.Now()
 	vs := msg.Args[1:]
@@ -458,6 +648,42 @@ func (config *Config) keepAlive() int64 {
 	config.mu.RUnlock()
 	return v
 }
+func (config *Config) luaPoolInit() int {
+	config.mu.RLock()
+	v := config._luaPoolInit
+	config.mu.RUnlock()
+	return int(v)
+}
+func (config *Config) luaPoolMax() int {
+	config.mu.RLock()
+	v := config._luaPoolMax
+	config.mu.RUnlock()
+	return int(v)
+}
+func (config *Config) defaultScanTimeout() time.Duration {
+	config.mu.RLock()
+	v := config._defaultScanTimeout
+	config.mu.RUnlock()
+	return v
+}
+func (config *Config) scriptCommandDenied(cmd string) bool {
+	config.mu.RLock()
+	v := config._scriptCommandDenylist[cmd]
+	config.mu.RUnlock()
+	return v
+}
+func (config *Config) memStatsPoller() bool {
+	config.mu.RLock()
+	v := config._memStatsPoller
+	config.mu.RUnlock()
+	return v == "yes"
+}
+func (config *Config) snapshotLegacy() bool {
+	config.mu.RLock()
+	v := config._snapshotLegacy
+	config.mu.RUnlock()
+	return v == "yes"
+}
 func (config *Config) setFollowHost(v string) {
 	config.mu.Lock()
 	config._followHost = v
@@ -430,6 +430,7 @@ func (server *Server) cmdDrop(msg *Message) (res resp.Value, d commandDetails, e
 	d.command = "drop"
 	d.timestamp = time.Now()
 	server.clearKeyExpires(d.key)
+	server.clearExpired(d.key)
 	switch msg.OutputType {
 	case JSON:
 		res = resp.StringValue(`{"ok":true,"elapsed":"` + time.Now().Sub(start).String() + "\"}")
@@ -485,6 +486,7 @@ func (server *Server) cmdRename(msg *Message, nx bool) (res resp.Value, d comman
 		server.deleteCol(d.key)
 		server.setCol(d.newKey, col)
 		server.moveKeyExpires(d.key, d.newKey)
+		server.moveExpired(d.key, d.newKey)
 	}
 	d.timestamp = time.Now()
 	switch msg.OutputType {
@@ -528,6 +530,7 @@ func (server *Server) doFlushDB() {
 	server.hooks = make(map[string]*Hook)
 	server.hooksOut = make(map[string]*Hook)
 	server.hookTree = rbang.RTree{}
+	server.resetExpired()
 }
 
 func (server *Server) parseSetArgs(vs []string) (
@@ -1,13 +1,17 @@
 package server
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"math/rand"
 	"strconv"
+	"strings"
 	"sync/atomic"
 	"time"
 
+	"github.com/tidwall/geojson"
+	"github.com/tidwall/geojson/geometry"
 	"github.com/tidwall/resp"
 	"github.com/tidwall/tile38/internal/log"
 )
@@ -154,3 +158,78 @@ func (s *Server) cmdSleep(msg *Message) (res resp.Value, err error) {
 	time.Sleep(time.Duration(float64(time.Second) * d))
 	return OKMessage(msg, start), nil
 }
+
+// DEBUG OBJECT key id
+
+func (s *Server) cmdDebug(msg *Message) (res resp.Value, err error) {
+	start := time.Now()
+	vs := msg.Args[1:]
+
+	var ok bool
+	var sub, key, id string
+	if vs, sub, ok = tokenval(vs); !ok || sub == "" {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	if strings.ToLower(sub) != "object" {
+		return NOMessage, errInvalidArgument(sub)
+	}
+	if vs, key, ok = tokenval(vs); !ok || key == "" {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	if vs, id, ok = tokenval(vs); !ok || id == "" {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	if len(vs) != 0 {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+
+	col := s.getCol(key)
+	if col == nil {
+		return NOMessage, errKeyNotFound
+	}
+	rect, path, ok := col.Placement(id)
+	if !ok {
+		return NOMessage, errIDNotFound
+	}
+
+	respBounds := func(rect geometry.Rect) resp.Value {
+		return resp.ArrayValue([]resp.Value{
+			resp.ArrayValue([]resp.Value{
+				resp.FloatValue(rect.Min.Y),
+				resp.FloatValue(rect.Min.X),
+			}),
+			resp.ArrayValue([]resp.Value{
+				resp.FloatValue(rect.Max.Y),
+				resp.FloatValue(rect.Max.X),
+			}),
+		})
+	}
+
+	switch msg.OutputType {
+	case JSON:
+		var buf bytes.Buffer
+		buf.WriteString(`{"ok":true,"bounds":`)
+		buf.Write(appendJSONSimpleBounds(nil, geojson.NewRect(rect)))
+		buf.WriteString(`,"depth":` + strconv.Itoa(len(path)))
+		buf.WriteString(`,"path":[`)
+		for i, node := range path {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.Write(appendJSONSimpleBounds(nil, geojson.NewRect(node)))
+		}
+		buf.WriteString(`],"elapsed":"` + time.Since(start).String() + "\"}")
+		return resp.StringValue(buf.String()), nil
+	case RESP:
+		nodes := make([]resp.Value, len(path))
+		for i, node := range path {
+			nodes[i] = respBounds(node)
+		}
+		return resp.ArrayValue([]resp.Value{
+			respBounds(rect),
+			resp.IntegerValue(len(path)),
+			resp.ArrayValue(nodes),
+		}), nil
+	}
+	return NOMessage, nil
+}
@@ -60,11 +60,66 @@ func (s *Server) getExpires(key, id string) (at time.Time, ok bool) {
 // hasExpired returns true if an item has expired.
 func (s *Server) hasExpired(key, id string) bool {
 	if at, ok := s.getExpires(key, id); ok {
-		return time.Now().After(at)
+		return !time.Now().Before(at)
 	}
 	return false
 }
 
+// addExpired counts an item expiration for the collection key.
+func (s *Server) addExpired(key string) {
+	s.statsExpired.add(1)
+	s.expiredmu.Lock()
+	s.expiredKeys[key]++
+	s.expiredmu.Unlock()
+}
+
+// clearExpired removes the expiration counter for the collection key.
+func (s *Server) clearExpired(key string) {
+	s.expiredmu.Lock()
+	delete(s.expiredKeys, key)
+	s.expiredmu.Unlock()
+}
+
+// moveExpired moves the expiration counter from a key to a newKey.
+func (s *Server) moveExpired(key, newKey string) {
+	s.expiredmu.Lock()
+	if n, ok := s.expiredKeys[key]; ok {
+		delete(s.expiredKeys, key)
+		s.expiredKeys[newKey] = n
+	} else {
+		delete(s.expiredKeys, newKey)
+	}
+	s.expiredmu.Unlock()
+}
+
+// resetExpired removes the expiration counters for all collections.
+func (s *Server) resetExpired() {
+	s.expiredmu.Lock()
+	s.expiredKeys = make(map[string]int)
+	s.expiredmu.Unlock()
+}
+
+// expiredCounts returns a copy of the per-collection expiration counters.
+func (s *Server) expiredCounts() map[string]int {
+	s.expiredmu.Lock()
+	counts := make(map[string]int, len(s.expiredKeys))
+	for key, n := range s.expiredKeys {
+		counts[key] = n
+	}
+	s.expiredmu.Unlock()
+	return counts
+}
+
+// hasKeyExpires returns true if any item in the collection key has an
+// expiration.
+func (s *Server) hasKeyExpires(key string) bool {
+	if s.expires.Len() == 0 {
+		return false
+	}
+	_, ok := s.expires.Get(key)
+	return ok
+}
+
 const bgExpireDelay = time.Second / 10
 const bgExpireSegmentSize = 20
 
@@ -92,6 +147,7 @@ func (s *Server) expirePurgeSweep(rng *rand.Rand) (purged int) {
 					if err := s.writeAOF(msg.Args, &d); err != nil {
 						log.Fatal(err)
 					}
+					s.addExpired(key)
 					purged++
 				}
 			}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math/rand"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -32,6 +33,23 @@ func (s *Server) cmdFollow(msg *Message) (res resp.Value, err error) {
 	if vs, sport, ok = tokenval(vs); !ok || sport == "" {
 		return NOMessage, errInvalidNumberOfArguments
 	}
+	// an optional FROM <offset> replays the leader's aof from that offset
+	// instead of syncing to the latest snapshot
+	from := int64(-1)
+	if len(vs) != 0 {
+		var tok, soffset string
+		if vs, tok, ok = tokenval(vs); !ok || strings.ToLower(tok) != "from" {
+			return NOMessage, errInvalidArgument(tok)
+		}
+		if vs, soffset, ok = tokenval(vs); !ok || soffset == "" {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		n, err := strconv.ParseInt(soffset, 10, 64)
+		if err != nil || n < 0 {
+			return NOMessage, errInvalidArgument(soffset)
+		}
+		from = n
+	}
 	if len(vs) != 0 {
 		return NOMessage, errInvalidNumberOfArguments
 	}
@@ -39,6 +57,9 @@ func (s *Server) cmdFollow(msg *Message) (res resp.Value, err error) {
 	sport = strings.ToLower(sport)
 	var update bool
 	if host == "no" && sport == "one" {
+		if from >= 0 {
+			return NOMessage, errInvalidNumberOfArguments
+		}
 		update = s.config.followHost() != "" || s.config.followPort() != 0
 		s.config.setFollowHost("")
 		s.config.setFollowPort(0)
@@ -48,12 +69,18 @@ func (s *Server) cmdFollow(msg *Message) (res resp.Value, err error) {
 			return NOMessage, errInvalidArgument(sport)
 		}
 		port := int(n)
-		update = s.config.followHost() != host || s.config.followPort() != port
+		update = s.config.followHost() != host || s.config.followPort() != port ||
+			from >= 0
 		if update {
 			if err = s.validateLeader(host, port); err != nil {
 				return NOMessage, err
 			}
 		}
+		if from >= 0 {
+			if err = s.validateFollowOffset(host, port, from); err != nil {
+				return NOMessage, err
+			}
+		}
 		s.config.setFollowHost(host)
 		s.config.setFollowPort(port)
 	}
@@ -62,7 +89,7 @@ func (s *Server) cmdFollow(msg *Message) (res resp.Value, err error) {
 		s.followc.add(1)
 		if s.config.followHost() != "" {
 			log.Infof("following new host '%s' '%s'.", host, sport)
-			go s.follow(s.config.followHost(), s.config.followPort(), s.followc.get())
+			go s.follow(s.config.followHost(), s.config.followPort(), s.followc.get(), from)
 		} else {
 			log.Infof("following no one")
 		}
@@ -86,6 +113,7 @@ func (s *Server) cmdReplConf(msg *Message, client *Client) (res resp.Value, err
 	}
 
 	// Switch on the command received
+	var apply func(c *Client)
 	switch cmd {
 	case "listening-port":
 		// Parse the port as an integer
@@ -93,17 +121,24 @@ func (s *Server) cmdReplConf(msg *Message, client *Client) (res resp.Value, err
 		if err != nil {
 			return NOMessage, errInvalidArgument(val)
 		}
+		apply = func(c *Client) { c.replPort = port }
+	case "ip-address":
+		// The address the follower is reachable at, which differs from the
+		// connection address when the follower is behind NAT
+		apply = func(c *Client) { c.replAddr = val }
+	default:
+		return NOMessage, fmt.Errorf("cannot find follower")
+	}
 
-		// Apply the replication port to the client and return
-		s.connsmu.RLock()
-		defer s.connsmu.RUnlock()
-		for _, c := range s.conns {
-			if c.remoteAddr == client.remoteAddr {
-				c.mu.Lock()
-				c.replPort = port
-				c.mu.Unlock()
-				return OKMessage(msg, start), nil
-			}
+	// Apply the setting to the client and return
+	s.connsmu.RLock()
+	defer s.connsmu.RUnlock()
+	for _, c := range s.conns {
+		if c.remoteAddr == client.remoteAddr {
+			c.mu.Lock()
+			apply(c)
+			c.mu.Unlock()
+			return OKMessage(msg, start), nil
 		}
 	}
 	return NOMessage, fmt.Errorf("cannot find follower")
@@ -211,9 +246,61 @@ func (s *Server) validateLeader(host string, port int) error {
 	if m["following"] != "" {
 		return fmt.Errorf("cannot follow a follower")
 	}
+	if !compatibleVersions(m["version"], core.Version) {
+		return fmt.Errorf("cannot follow: version mismatch: leader %s, follower %s",
+			m["version"], core.Version)
+	}
+	return nil
+}
+
+// validateFollowOffset checks that the offset is within the leader's aof.
+func (s *Server) validateFollowOffset(host string, port int, offset int64) error {
+	conn, err := DialTimeout(fmt.Sprintf("%s:%d", host, port), time.Second*2)
+	if err != nil {
+		return fmt.Errorf("cannot follow: %v", err)
+	}
+	defer conn.Close()
+	if auth := s.config.leaderAuth(); auth != "" {
+		if err := s.followDoLeaderAuth(conn, auth); err != nil {
+			return fmt.Errorf("cannot follow: %v", err)
+		}
+	}
+	m, err := doServer(conn)
+	if err != nil {
+		return fmt.Errorf("cannot follow: %v", err)
+	}
+	lSize, err := strconv.ParseInt(m["aof_size"], 10, 64)
+	if err != nil {
+		return fmt.Errorf("cannot follow: invalid aof_size")
+	}
+	if offset > lSize {
+		return fmt.Errorf("cannot follow: offset %d is beyond the leader's aof size %d",
+			offset, lSize)
+	}
 	return nil
 }
 
+// compatibleVersions returns true if the leader and follower versions have
+// the same major version. Leaders that do not report a version are allowed.
+func compatibleVersions(leader, follower string) bool {
+	if leader == "" {
+		return true
+	}
+	major := func(version string) string {
+		return strings.SplitN(strings.TrimPrefix(version, "v"), ".", 2)[0]
+	}
+	return major(leader) == major(follower)
+}
+
+// replicationLag returns the number of bytes the follower is behind the
+// leader, given their positions relative to the last synced snapshot.
+func replicationLag(leaderPos, followerPos int64) int64 {
+	if followerPos >= leaderPos {
+		return 0
+	}
+	return leaderPos - followerPos
+}
+
 func (s *Server) catchUpAndKeepUp(host string, port int, followc int, lTop, fTop int64) error {
 	if s.followc.get() != followc {
 		return errNoLongerFollowing
@@ -270,6 +357,7 @@ func (s *Server) catchUpAndKeepUp(host string, port int, followc int, lTop, fTop
 		log.Debug("follow:", addr, ":read aof")
 	}
 
+	s.statsReplicationLag.set(int(replicationLag(lSize-lTop, relPos)))
 	caughtUp := relPos >= lSize-lTop
 	if caughtUp {
 		ul := s.WriterLock()
@@ -297,6 +385,7 @@ func (s *Server) catchUpAndKeepUp(host string, port int, followc int, lTop, fTop
 		if err != nil {
 			return err
 		}
+		s.statsReplicationLag.set(int(replicationLag(lSize-lTop, fSize-fTop)))
 		if !caughtUp {
 			if fSize-fTop >= lSize-lTop {
 				caughtUp = true
@@ -365,13 +454,20 @@ func (s * Server) syncToLatestSnapshot(host string, port int, followc int) (lTop
 	return
 }
 
-func (s *Server) follow(host string, port int, followc int) {
+// follow replicates from the leader. A non-negative from offset starts at
+// that offset of the leader's aof, otherwise at its latest snapshot.
+func (s *Server) follow(host string, port int, followc int, from int64) {
 	var lTop, fTop int64
 	var err error
 
-	if lTop, fTop, err = s.syncToLatestSnapshot(host, port, followc); err != nil {
+	backoff := newFollowBackoff()
+	if from >= 0 {
+		ul := s.WriterLock()
+		lTop, fTop = from, s.aofsz
+		ul()
+	} else if lTop, fTop, err = s.syncToLatestSnapshot(host, port, followc); err != nil {
 		log.Errorf("follow: failed to sync to the latest snapshot: %v", err)
-		time.Sleep(time.Second)
+		time.Sleep(backoff.next())
 	}
 
 	// Each step of this loop is an attempt to start and maintain replication.
@@ -399,6 +495,49 @@ func (s *Server) follow(host string, port int, followc int) {
 			// unexpected error: log and try again
 			log.Error("follow: " + err.Error())
 		}
-		time.Sleep(time.Second)
+		// a connection that caught up was healthy, so start over with short
+		// delays rather than continuing to back off
+		ul := s.ReaderLock()
+		caughtUp := s.fcup
+		ul()
+		if caughtUp {
+			backoff.reset()
+		}
+		time.Sleep(backoff.next())
 	}
 }
+
+const (
+	followBackoffBase = time.Second
+	followBackoffMax  = time.Second * 30
+)
+
+// followBackoff is the exponential backoff between attempts to connect to the
+// leader.
+type followBackoff struct {
+	interval time.Duration
+	rng      *rand.Rand
+}
+
+func newFollowBackoff() *followBackoff {
+	return &followBackoff{
+		interval: followBackoffBase,
+		rng:      rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// next returns the delay before the next attempt, which is a random duration
+// between half and all of the current interval, and doubles the interval.
+func (b *followBackoff) next() time.Duration {
+	d := b.interval/2 + time.Duration(b.rng.Int63n(int64(b.interval/2)+1))
+	b.interval *= 2
+	if b.interval > followBackoffMax {
+		b.interval = followBackoffMax
+	}
+	return d
+}
+
+// reset returns to the base interval.
+func (b *followBackoff) reset() {
+	b.interval = followBackoffBase
+}
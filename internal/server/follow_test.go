@@ -0,0 +1,158 @@
+package server
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/resp"
+)
+
+func TestReplicationLag(t *testing.T) {
+	const lSize, lTop, fTop = 1000, 100, 40
+	var last int64 = lSize - lTop + 1
+	for fSize := int64(fTop); fSize <= fTop+lSize-lTop+50; fSize += 50 {
+		lag := replicationLag(lSize-lTop, fSize-fTop)
+		if lag < 0 || lag >= last && lag != 0 {
+			t.Fatalf("expected decreasing lag, got %d after %d", lag, last)
+		}
+		last = lag
+	}
+	if last != 0 {
+		t.Fatalf("expected no lag once caught up, got %d", last)
+	}
+	if lag := replicationLag(lSize-lTop, 0); lag != lSize-lTop {
+		t.Fatalf("expected %d, got %d", lSize-lTop, lag)
+	}
+}
+
+// mockLeader answers every SERVER request with the passed stats
+func mockLeader(t *testing.T, stats ...string) (addr *net.TCPAddr, close func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				rd := resp.NewReader(conn)
+				wr := resp.NewWriter(conn)
+				for {
+					v, _, err := rd.ReadValue()
+					if err != nil {
+						return
+					}
+					if strings.ToLower(v.Array()[0].String()) != "server" {
+						return
+					}
+					var vals []resp.Value
+					for _, stat := range stats {
+						vals = append(vals, resp.StringValue(stat))
+					}
+					wr.WriteArray(vals)
+				}
+			}(conn)
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr), func() { ln.Close() }
+}
+
+func TestValidateLeaderVersion(t *testing.T) {
+	s := &Server{config: &Config{_serverID: "follower"}}
+
+	addr, close := mockLeader(t, "id", "leader", "version", "999.0.0")
+	defer close()
+	err := s.validateLeader(addr.IP.String(), addr.Port)
+	if err == nil || !strings.HasPrefix(err.Error(), "cannot follow: version mismatch") {
+		t.Fatalf("expected a version mismatch, got '%v'", err)
+	}
+
+	addr, close = mockLeader(t, "id", "leader", "version", "v0.1.0")
+	defer close()
+	if err := s.validateLeader(addr.IP.String(), addr.Port); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCompatibleVersions(t *testing.T) {
+	for _, test := range []struct {
+		leader, follower string
+		ok               bool
+	}{
+		{"1.19.0", "1.21.3", true},
+		{"v1.19.0", "1.21.3", true},
+		{"2.0.0", "1.21.3", false},
+		{"", "1.21.3", true},
+	} {
+		if ok := compatibleVersions(test.leader, test.follower); ok != test.ok {
+			t.Fatalf("compatibleVersions(%q, %q): expected %v, got %v",
+				test.leader, test.follower, test.ok, ok)
+		}
+	}
+}
+
+func TestFollowFromOffset(t *testing.T) {
+	s := &Server{config: &Config{_serverID: "follower"}}
+	addr, close := mockLeader(t, "id", "leader", "aof_size", "1000")
+	defer close()
+	port := strconv.Itoa(addr.Port)
+
+	for _, test := range []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"follow", "127.0.0.1", port, "FROM", "2000"},
+			"cannot follow: offset 2000 is beyond the leader's aof size 1000"},
+		{[]string{"follow", "127.0.0.1", port, "FROM", "-1"},
+			"invalid argument '-1'"},
+		{[]string{"follow", "127.0.0.1", port, "AT", "10"},
+			"invalid argument 'AT'"},
+		{[]string{"follow", "127.0.0.1", port, "FROM"},
+			"invalid number of arguments"},
+		{[]string{"follow", "no", "one", "FROM", "10"},
+			"invalid number of arguments"},
+	} {
+		_, err := s.cmdFollow(&Message{Args: test.args})
+		if err == nil || err.Error() != test.err {
+			t.Fatalf("%v: expected '%s', got '%v'", test.args, test.err, err)
+		}
+	}
+	if err := s.validateFollowOffset("127.0.0.1", addr.Port, 1000); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFollowBackoff(t *testing.T) {
+	b := newFollowBackoff()
+	interval := followBackoffBase
+	for i := 0; i < 10; i++ {
+		d := b.next()
+		if d < interval/2 || d > interval {
+			t.Fatalf("attempt %d: expected a delay between %v and %v, got %v",
+				i, interval/2, interval, d)
+		}
+		interval *= 2
+		if interval > followBackoffMax {
+			interval = followBackoffMax
+		}
+		if b.interval != interval {
+			t.Fatalf("attempt %d: expected the interval to grow to %v, got %v",
+				i, interval, b.interval)
+		}
+	}
+	if b.interval != followBackoffMax {
+		t.Fatalf("expected the interval to be capped at %v, got %v",
+			followBackoffMax, b.interval)
+	}
+	b.reset()
+	if d := b.next(); d > followBackoffBase {
+		t.Fatalf("expected a delay of at most %v after reset, got %v",
+			followBackoffBase, d)
+	}
+}
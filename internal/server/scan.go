@@ -59,7 +59,8 @@ func (s *Server) cmdScan(msg *Message) (res resp.Value, err error) {
 	sc.writeHead()
 	if sc.col != nil {
 		if sc.output == outputCount && len(sc.wheres) == 0 &&
-			len(sc.whereins) == 0 && sc.globEverything == true {
+			len(sc.whereins) == 0 && sc.globEverything == true &&
+			!s.hasKeyExpires(args.key) {
 			count := sc.col.Count() - int(args.cursor)
 			if count < 0 {
 				count = 0
@@ -71,6 +72,9 @@ func (s *Server) cmdScan(msg *Message) (res resp.Value, err error) {
 				sc.col.Scan(args.desc, sc,
 					msg.Deadline,
 					func(id string, o geojson.Object, fields []float64) bool {
+						if s.hasExpired(args.key, id) {
+							return true
+						}
 						return sc.writeObject(ScanObjectParams{
 							id:     id,
 							o:      o,
@@ -82,6 +86,9 @@ func (s *Server) cmdScan(msg *Message) (res resp.Value, err error) {
 				sc.col.ScanRange(g.Limits[0], g.Limits[1], args.desc, sc,
 					msg.Deadline,
 					func(id string, o geojson.Object, fields []float64) bool {
+						if s.hasExpired(args.key, id) {
+							return true
+						}
 						return sc.writeObject(ScanObjectParams{
 							id:     id,
 							o:      o,
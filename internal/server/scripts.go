@@ -9,6 +9,7 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -16,6 +17,7 @@ import (
 
 	"github.com/tidwall/geojson"
 	"github.com/tidwall/geojson/geo"
+	"github.com/tidwall/geojson/geometry"
 	"github.com/tidwall/resp"
 	"github.com/tidwall/tile38/internal/collection"
 	"github.com/tidwall/tile38/internal/deadline"
@@ -28,15 +30,24 @@ import (
 const (
 	iniLuaPoolSize = 5
 	maxLuaPoolSize = 1000
+
+	// upper bounds for the lua-pool-init and lua-pool-max settings
+	maxLuaPoolInit = 1000
+	maxLuaPoolMax  = 100000
 )
 
 var errShaNotFound = errors.New("sha not found")
+var errScriptNameNotFound = errors.New("script name not found")
 var errCmdNotSupported = errors.New("command not supported in scripts")
 var errNotLeader = errors.New("not the leader")
 var errReadOnly = errors.New("read only")
 var errCatchingUp = errors.New("catching up to leader")
 var errNoLuasAvailable = errors.New("no interpreters available")
 var errTimeout = errors.New("timeout")
+var errScriptTimeout = errors.New("script timeout")
+var errScriptKilled = errors.New("script killed by user")
+var errNoScriptRunning = errors.New("no scripts in execution right now")
+var errScriptUnkillable = errors.New("script already executed write commands and cannot be killed")
 
 // Go-routine-safe pool of read-to-go lua states
 type lStatePool struct {
@@ -48,12 +59,13 @@ type lStatePool struct {
 
 // newPool returns a new pool of lua states
 func (s *Server) newPool() *lStatePool {
+	n := s.config.luaPoolInit()
 	pl := &lStatePool{
-		saved: make([]*lua.LState, iniLuaPoolSize),
+		saved: make([]*lua.LState, n),
 		s:     s,
 	}
 	// Fill the pool with some ready handlers
-	for i := 0; i < iniLuaPoolSize; i++ {
+	for i := 0; i < n; i++ {
 		pl.saved[i] = pl.New()
 		pl.total++
 	}
@@ -65,7 +77,7 @@ func (pl *lStatePool) Get() (*lua.LState, error) {
 	defer pl.m.Unlock()
 	n := len(pl.saved)
 	if n == 0 {
-		if pl.total >= maxLuaPoolSize {
+		if pl.total >= pl.s.config.luaPoolMax() {
 			return nil, errNoLuasAvailable
 		}
 		pl.total++
@@ -80,9 +92,9 @@ func (pl *lStatePool) Get() (*lua.LState, error) {
 func (pl *lStatePool) Prune() {
 	pl.m.Lock()
 	n := len(pl.saved)
-	if n > iniLuaPoolSize {
+	if ini := pl.s.config.luaPoolInit(); n > ini {
 		// drop half of the idle states that is above the minimum
-		dropNum := (n - iniLuaPoolSize) / 2
+		dropNum := (n - ini) / 2
 		if dropNum < 1 {
 			dropNum = 1
 		}
@@ -103,7 +115,19 @@ func (pl *lStatePool) New() *lua.LState {
 		// Trying to work with unknown number of args.
 		// When we see empty arg we call it enough.
 		for i := 1; ; i++ {
-			if arg := ls.ToString(i); arg == "" {
+			if tbl, ok := ls.Get(i).(*lua.LTable); ok {
+				// Tables are flattened in place, which allows for
+				// tile38.call('set', {'fleet', 'truck1', 'point', 33, -112})
+				for j := 1; j <= tbl.Len(); j++ {
+					switch v := tbl.RawGetInt(j); v.Type() {
+					case lua.LTString, lua.LTNumber:
+						args = append(args, v.String())
+					default:
+						ls.RaiseError("invalid argument type %s in table, "+
+							"expected string or number", v.Type())
+					}
+				}
+			} else if arg := ls.ToString(i); arg == "" {
 				break
 			} else {
 				args = append(args, arg)
@@ -111,10 +135,23 @@ func (pl *lStatePool) New() *lua.LState {
 		}
 		return
 	}
+	// timedCall runs a tile38 command and records how long it took for
+	// tile38.last_call_elapsed. A script is marked as a writer before a write
+	// command runs, so SCRIPT KILL can't stop it once the write is applied.
+	timedCall := func(ls *lua.LState, evalCmd, cmd string, args ...string) (resp.Value, error) {
+		if luaWriteCommand(cmd) && !luaReadOnlyEval(evalCmd) {
+			pl.s.luarunning.MarkWrite(ls)
+		}
+		start := time.Now()
+		res, err := pl.s.luaTile38Call(evalCmd, cmd, args...)
+		ls.G.Registry.RawSetString(luaLastCallElapsed,
+			lua.LNumber(time.Since(start).Seconds()))
+		return res, err
+	}
 	call := func(ls *lua.LState) int {
 		evalCmd, args := getArgs(ls)
 		var numRet int
-		if res, err := pl.s.luaTile38Call(evalCmd, args[0], args[1:]...); err != nil {
+		if res, err := timedCall(ls, evalCmd, args[0], args[1:]...); err != nil {
 			ls.RaiseError("ERR %s", err.Error())
 			numRet = 0
 		} else {
@@ -125,14 +162,81 @@ func (pl *lStatePool) New() *lua.LState {
 	}
 	pcall := func(ls *lua.LState) int {
 		evalCmd, args := getArgs(ls)
-		if res, err := pl.s.luaTile38Call(evalCmd, args[0], args[1:]...); err != nil {
-			ls.Push(ConvertToLua(ls, resp.ErrorValue(err)))
+		if res, err := timedCall(ls, evalCmd, args[0], args[1:]...); err != nil {
+			tbl := ConvertToLua(ls, resp.ErrorValue(err)).(*lua.LTable)
+			tbl.RawSetString("code", lua.LString(luaErrorCode(err)))
+			ls.Push(tbl)
 		} else {
 			ls.Push(ConvertToLua(ls, res))
 		}
 		return 1
 
 	}
+	// mset sets many objects in one key, e.g.
+	// tile38.mset('fleet', {{'truck1', 'POINT', 33, -115}, {'truck2', ...}})
+	mset := func(ls *lua.LState) int {
+		evalCmd := ls.GetGlobal("EVAL_CMD").String()
+		key, tbl := ls.CheckString(1), ls.CheckTable(2)
+		entries := make([][]string, 0, tbl.Len())
+		for i := 1; i <= tbl.Len(); i++ {
+			row, ok := tbl.RawGetInt(i).(*lua.LTable)
+			if !ok {
+				ls.RaiseError("invalid entry %d, expected a table", i)
+			}
+			entry := make([]string, 0, row.Len())
+			for j := 1; j <= row.Len(); j++ {
+				switch v := row.RawGetInt(j); v.Type() {
+				case lua.LTString, lua.LTNumber:
+					entry = append(entry, v.String())
+				default:
+					ls.RaiseError("invalid argument type %s in entry %d, "+
+						"expected string or number", v.Type(), i)
+				}
+			}
+			entries = append(entries, entry)
+		}
+		if len(entries) > 0 && !luaReadOnlyEval(evalCmd) {
+			pl.s.luarunning.MarkWrite(ls)
+		}
+		start := time.Now()
+		n, err := pl.s.luaTile38MSet(evalCmd, key, entries)
+		ls.G.Registry.RawSetString(luaLastCallElapsed,
+			lua.LNumber(time.Since(start).Seconds()))
+		if err != nil {
+			ls.RaiseError("ERR %s", err.Error())
+			return 0
+		}
+		ls.Push(lua.LNumber(n))
+		return 1
+	}
+	// fsetMany sets all fields from a table of names to values with a single
+	// FSET, e.g. tile38.fset_many('fleet', 'truck1', {speed=55, heading=90})
+	fsetMany := func(ls *lua.LState) int {
+		evalCmd := ls.GetGlobal("EVAL_CMD").String()
+		key, id, tbl := ls.CheckString(1), ls.CheckString(2), ls.CheckTable(3)
+		fields := make(map[string]string)
+		var names []string
+		tbl.ForEach(func(k, v lua.LValue) {
+			if k.Type() != lua.LTString || v.Type() != lua.LTNumber {
+				ls.RaiseError("invalid field %s=%s, expected a name and a number",
+					k.String(), v.String())
+			}
+			names = append(names, k.String())
+			fields[k.String()] = v.String()
+		})
+		sort.Strings(names)
+		args := []string{key, id}
+		for _, name := range names {
+			args = append(args, name, fields[name])
+		}
+		res, err := timedCall(ls, evalCmd, "fset", args...)
+		if err != nil {
+			ls.RaiseError("ERR %s", err.Error())
+			return 0
+		}
+		ls.Push(ConvertToLua(ls, res))
+		return 1
+	}
 	errorReply := func(ls *lua.LState) int {
 		tbl := L.CreateTable(0, 1)
 		tbl.RawSetString("err", lua.LString(ls.ToString(1)))
@@ -145,6 +249,12 @@ func (pl *lStatePool) New() *lua.LState {
 		ls.Push(tbl)
 		return 1
 	}
+	floatValue := func(ls *lua.LState) int {
+		tbl := L.CreateTable(0, 1)
+		tbl.RawSetString("float", ls.CheckNumber(1))
+		ls.Push(tbl)
+		return 1
+	}
 	sha1hex := func(ls *lua.LState) int {
 		shaSum := Sha1Sum(ls.ToString(1))
 		ls.Push(lua.LString(shaSum))
@@ -159,6 +269,9 @@ func (pl *lStatePool) New() *lua.LState {
 		ls.Push(lua.LNumber(dt))
 		return 1
 	}
+	// iterate passes its arguments straight to the command parser, so a
+	// script can resume a previous iteration with CURSOR and the cursor
+	// string returned by that call.
 	iterate := func(ls *lua.LState) int {
 		evalCmd := ls.GetGlobal("EVAL_CMD").String()
 		callback := ls.ToFunction(1)
@@ -191,9 +304,10 @@ func (pl *lStatePool) New() *lua.LState {
 			itr: itr,
 		}
 
-		err := pl.s.luaTile38Iterate(coll, dl, evalCmd, strings.ToLower(cmd), vs)
+		cmd = strings.ToLower(cmd)
+		err := pl.s.luaTile38Iterate(coll, dl, evalCmd, cmd, vs)
 		if err != nil {
-			ls.RaiseError("%v", err)
+			ls.RaiseError("%s: %v", cmd, err)
 		}
 		ls.Push(lua.LString(strconv.FormatUint(coll.cursor, 10)))
 		return 1
@@ -230,16 +344,89 @@ func (pl *lStatePool) New() *lua.LState {
 		ls.Push(result)
 		return 1
 	}
+	getGeoObject := func(ls *lua.LState) int {
+		evalCmd := ls.GetGlobal("EVAL_CMD").String()
+		colName := ls.ToString(1)
+		id := ls.ToString(2)
+		result, err := pl.s.luaTile38Get(ls, evalCmd, colName, id)
+		if err != nil {
+			ls.RaiseError("%v", err)
+		}
+		if ud, ok := result.(*lua.LUserData); ok {
+			gobj := ls.NewUserData()
+			gobj.Metatable = ls.GetTypeMetatable(luaGeoJSONObjectTypeName)
+			gobj.Value = ud.Value.(*luaCollectionItem).o
+			result = gobj
+		}
+		ls.Push(result)
+		return 1
+	}
+	validateGeoJSON := func(ls *lua.LState) int {
+		if _, err := geojson.Parse(ls.CheckString(1), &pl.s.geomParseOpts); err != nil {
+			ls.Push(lua.LFalse)
+			ls.Push(lua.LString(err.Error()))
+			return 2
+		}
+		ls.Push(lua.LTrue)
+		return 1
+	}
+	serverTime := func(ls *lua.LState) int {
+		now := time.Now()
+		ls.Push(lua.LNumber(now.Unix()))
+		ls.Push(lua.LNumber(now.Nanosecond() / 1000))
+		return 2
+	}
+	deadlineRemaining := func(ls *lua.LState) int {
+		remaining := lua.LNumber(math.MaxInt32)
+		if ctx := ls.Context(); ctx != nil {
+			if dl, ok := ctx.Deadline(); ok {
+				remaining = lua.LNumber(time.Until(dl).Seconds())
+			}
+		}
+		ls.Push(remaining)
+		return 1
+	}
+	lastCallElapsed := func(ls *lua.LState) int {
+		elapsed := ls.G.Registry.RawGetString(luaLastCallElapsed)
+		if elapsed == lua.LNil {
+			elapsed = lua.LNumber(0)
+		}
+		ls.Push(elapsed)
+		return 1
+	}
+	logMessage := func(ls *lua.LState) int {
+		msg := ls.ToString(2)
+		switch strings.ToLower(ls.ToString(1)) {
+		case "debug":
+			log.Debug(msg)
+		case "warn":
+			log.Warn(msg)
+		case "error":
+			log.Error(msg)
+		default:
+			log.Info(msg)
+		}
+		return 0
+	}
 	var exports = map[string]lua.LGFunction{
-		"call":          call,
-		"pcall":         pcall,
-		"error_reply":   errorReply,
-		"status_reply":  statusReply,
-		"sha1hex":       sha1hex,
-		"distance_to":   distanceTo,
-		"iterate":       iterate,
-		"field_indexes": fieldIndexes,
-		"get":           getObject,
+		"call":               call,
+		"pcall":              pcall,
+		"fset_many":          fsetMany,
+		"mset":               mset,
+		"error_reply":        errorReply,
+		"status_reply":       statusReply,
+		"float_value":        floatValue,
+		"sha1hex":            sha1hex,
+		"distance_to":        distanceTo,
+		"iterate":            iterate,
+		"field_indexes":      fieldIndexes,
+		"get":                getObject,
+		"get_object":         getGeoObject,
+		"log":                logMessage,
+		"time":               serverTime,
+		"validate_geojson":   validateGeoJSON,
+		"deadline_remaining": deadlineRemaining,
+		"last_call_elapsed":  lastCallElapsed,
 	}
 	L.SetGlobal("tile38", L.SetFuncs(L.NewTable(), exports))
 
@@ -263,10 +450,26 @@ func (pl *lStatePool) New() *lua.LState {
 
 func (pl *lStatePool) Put(L *lua.LState) {
 	pl.m.Lock()
+	if pl.total > pl.s.config.luaPoolMax() {
+		// the maximum was lowered, shrink the pool instead of keeping L
+		pl.total--
+		pl.m.Unlock()
+		L.Close()
+		return
+	}
 	pl.saved = append(pl.saved, L)
 	pl.m.Unlock()
 }
 
+// Stats returns the number of lua states in the pool and how many of them
+// are idle
+func (pl *lStatePool) Stats() (total, idle int) {
+	pl.m.Lock()
+	total, idle = pl.total, len(pl.saved)
+	pl.m.Unlock()
+	return
+}
+
 func (pl *lStatePool) Shutdown() {
 	pl.m.Lock()
 	for _, L := range pl.saved {
@@ -279,6 +482,7 @@ func (pl *lStatePool) Shutdown() {
 type lScriptMap struct {
 	m       sync.Mutex
 	scripts map[string]*lua.FunctionProto
+	tags    map[string]string // tag to sha
 }
 
 func (sm *lScriptMap) Get(key string) (script *lua.FunctionProto, ok bool) {
@@ -294,19 +498,135 @@ func (sm *lScriptMap) Put(key string, script *lua.FunctionProto) {
 	sm.m.Unlock()
 }
 
+// GetTagged returns the script and its sha for a tag registered with PutTagged
+func (sm *lScriptMap) GetTagged(tag string) (
+	script *lua.FunctionProto, shaSum string, ok bool,
+) {
+	sm.m.Lock()
+	if shaSum, ok = sm.tags[tag]; ok {
+		script, ok = sm.scripts[shaSum]
+	}
+	sm.m.Unlock()
+	return
+}
+
+// PutTagged stores the script under its sha and also makes it reachable
+// through a human readable tag.
+func (sm *lScriptMap) PutTagged(key, tag string, script *lua.FunctionProto) {
+	sm.m.Lock()
+	sm.scripts[key] = script
+	sm.tags[tag] = key
+	sm.m.Unlock()
+}
+
 func (sm *lScriptMap) Flush() {
 	sm.m.Lock()
 	sm.scripts = make(map[string]*lua.FunctionProto)
+	sm.tags = make(map[string]string)
 	sm.m.Unlock()
 }
 
+// A script that is currently running, tracked so that SCRIPT KILL can stop it
+type lRunningScript struct {
+	cancel  context.CancelFunc
+	started time.Time
+	wrote   bool
+	killed  bool
+}
+
+// Go-routine-safe set of running scripts
+type lRunningScripts struct {
+	m       sync.Mutex
+	running map[*lua.LState]*lRunningScript
+}
+
+func (rs *lRunningScripts) Add(L *lua.LState, cancel context.CancelFunc) {
+	rs.m.Lock()
+	rs.running[L] = &lRunningScript{cancel: cancel, started: time.Now()}
+	rs.m.Unlock()
+}
+
+func (rs *lRunningScripts) Remove(L *lua.LState) {
+	rs.m.Lock()
+	delete(rs.running, L)
+	rs.m.Unlock()
+}
+
+// MarkWrite records that the script running in L has modified the dataset
+func (rs *lRunningScripts) MarkWrite(L *lua.LState) {
+	rs.m.Lock()
+	if r, ok := rs.running[L]; ok {
+		r.wrote = true
+	}
+	rs.m.Unlock()
+}
+
+// Kill cancels the longest running script that has not written anything
+// yet. Read only and non-atomic scripts may run concurrently, so only the
+// oldest one, the likeliest to be stuck, is stopped per call. Scripts that
+// did write are left running to keep them atomic.
+func (rs *lRunningScripts) Kill() error {
+	rs.m.Lock()
+	defer rs.m.Unlock()
+	if len(rs.running) == 0 {
+		return errNoScriptRunning
+	}
+	var oldest *lRunningScript
+	var writers int
+	for _, r := range rs.running {
+		switch {
+		case r.killed:
+		case r.wrote:
+			writers++
+		case oldest == nil || r.started.Before(oldest.started):
+			oldest = r
+		}
+	}
+	if oldest == nil {
+		if writers == 0 {
+			// only scripts that are already being killed
+			return errNoScriptRunning
+		}
+		return errScriptUnkillable
+	}
+	oldest.cancel()
+	oldest.killed = true
+	return nil
+}
+
+// newRunningScripts returns a new empty set of running scripts
+func (s *Server) newRunningScripts() *lRunningScripts {
+	return &lRunningScripts{
+		running: make(map[*lua.LState]*lRunningScript),
+	}
+}
+
 // NewScriptMap returns a new map with lua scripts
 func (s *Server) newScriptMap() *lScriptMap {
 	return &lScriptMap{
 		scripts: make(map[string]*lua.FunctionProto),
+		tags:    make(map[string]string),
 	}
 }
 
+// luaErrorCode returns a stable code for the errors that tile38.pcall
+// reports, so scripts don't need to match on the error message.
+func luaErrorCode(err error) string {
+	switch err {
+	case errReadOnly:
+		return "READONLY"
+	case errNotLeader:
+		return "NOTLEADER"
+	case errCatchingUp:
+		return "CATCHINGUP"
+	case errTimeout:
+		return "TIMEOUT"
+	case errCmdNotSupported:
+		return "NOTSUPPORTED"
+	}
+	return "ERR"
+}
+
 // ConvertToLua converts RESP value to lua LValue
 func ConvertToLua(L *lua.LState, val resp.Value) lua.LValue {
 	if val.IsNull() {
@@ -335,7 +655,9 @@ func ConvertToLua(L *lua.LState, val resp.Value) lua.LValue {
 	return lua.LString("ERR: unknown RESP type: " + val.Type().String())
 }
 
-// ConvertToRESP convert lua LValue to RESP value
+// ConvertToRESP convert lua LValue to RESP value.
+// Numbers are floored to integers unless wrapped with tile38.float_value,
+// in which case the fractional part is preserved.
 func ConvertToRESP(val lua.LValue) resp.Value {
 	switch val.Type() {
 	case lua.LTNil:
@@ -356,6 +678,8 @@ func ConvertToRESP(val lua.LValue) resp.Value {
 	case lua.LTTable:
 		var values []resp.Value
 		var specialValues []resp.Value
+		var codes int
+		var isErr bool
 		var cb func(lk lua.LValue, lv lua.LValue)
 		tbl := val.(*lua.LTable)
 
@@ -372,6 +696,13 @@ func ConvertToRESP(val lua.LValue) resp.Value {
 						specialValues = append(specialValues, resp.SimpleStringValue(lv.String()))
 					case "err":
 						specialValues = append(specialValues, resp.ErrorValue(errors.New(lv.String())))
+						isErr = true
+					case "code":
+						codes++
+					case "float":
+						if lv.Type() == lua.LTNumber {
+							specialValues = append(specialValues, resp.FloatValue(float64(lv.(lua.LNumber))))
+						}
 					}
 				}
 				values = append(values, resp.ArrayValue(
@@ -379,7 +710,14 @@ func ConvertToRESP(val lua.LValue) resp.Value {
 			}
 		}
 		tbl.ForEach(cb)
-		if len(values) == 1 && len(specialValues) == 1 {
+		n := len(values)
+		if isErr {
+			// an error table from tile38.pcall also carries its code
+			n -= codes
+		}
+		// ok, err and float only mark a reply when they are the sole key,
+		// so user tables that happen to use those keys are kept as is
+		if n == 1 && len(specialValues) == 1 {
 			return specialValues[0]
 		}
 		return resp.ArrayValue(values)
@@ -406,31 +744,63 @@ func ConvertToJSON(val lua.LValue) string {
 			return string(b)
 		}
 	case lua.LTTable:
-		var values []string
-		var cb func(lk lua.LValue, lv lua.LValue)
-		var start, end string
-
 		tbl := val.(*lua.LTable)
-		if tbl.Len() != 0 { // list
-			start = `[`
-			end = `]`
-			cb = func(lk lua.LValue, lv lua.LValue) {
-				values = append(values, ConvertToJSON(lv))
+		if f, ok := luaFloatValue(tbl); ok {
+			return ConvertToJSON(f)
+		}
+
+		// Entries are emitted in a stable order: the array part in index
+		// order, then any remaining entries sorted by their encoded key.
+		// A table with an array part becomes a JSON array holding the
+		// array items followed by the values of the remaining entries.
+		n := tbl.Len()
+		var values []string
+		for i := 1; i <= n; i++ {
+			values = append(values, ConvertToJSON(tbl.RawGetInt(i)))
+		}
+		type entry struct{ key, val string }
+		var entries []entry
+		tbl.ForEach(func(lk lua.LValue, lv lua.LValue) {
+			if num, ok := lk.(lua.LNumber); ok && n != 0 {
+				if i := int(num); lua.LNumber(i) == num && i >= 1 && i <= n {
+					return
+				}
 			}
-		} else { // map
-			start = `{`
-			end = `}`
-			cb = func(lk lua.LValue, lv lua.LValue) {
-				values = append(
-					values, ConvertToJSON(lk)+`:`+ConvertToJSON(lv))
+			entries = append(entries, entry{ConvertToJSON(lk), ConvertToJSON(lv)})
+		})
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].key < entries[j].key
+		})
+		if n != 0 { // list
+			for _, e := range entries {
+				values = append(values, e.val)
 			}
+			return `[` + strings.Join(values, `,`) + `]`
 		}
-		tbl.ForEach(cb)
-		return start + strings.Join(values, `,`) + end
+		for _, e := range entries { // map
+			values = append(values, e.key+`:`+e.val)
+		}
+		return `{` + strings.Join(values, `,`) + `}`
 	}
 	return "Unsupported lua type: " + val.Type().String()
 }
 
+// luaFloatValue returns the number wrapped by tile38.float_value, if tbl is
+// such a wrapper.
+func luaFloatValue(tbl *lua.LTable) (lua.LNumber, bool) {
+	if tbl.Len() != 0 {
+		return 0, false
+	}
+	lk, lv := tbl.Next(lua.LNil)
+	if lk != lua.LString("float") || lv.Type() != lua.LTNumber {
+		return 0, false
+	}
+	if next, _ := tbl.Next(lk); next != lua.LNil {
+		return 0, false
+	}
+	return lv.(lua.LNumber), true
+}
+
 func luaSetRawGlobals(ls *lua.LState, tbl map[string]lua.LValue) {
 	gt := ls.Get(lua.GlobalsIndex).(*lua.LTable)
 	for key, val := range tbl {
@@ -450,7 +820,7 @@ func makeSafeErr(err error) error {
 	return errors.New(strings.Replace(err.Error(), "\n", `\n`, -1))
 }
 
-// Run eval/evalro/evalna command or it's -sha variant
+// Run eval/evalro/evalna command or it's -sha variant, or evalname
 func (s *Server) cmdEvalUnified(scriptIsSha bool, msg *Message) (res resp.Value, err error) {
 	start := time.Now()
 	vs := msg.Args[1:]
@@ -475,16 +845,26 @@ func (s *Server) cmdEvalUnified(scriptIsSha bool, msg *Message) (res resp.Value,
 	if err != nil {
 		return
 	}
+	defer s.luapool.Put(luaState)
+
+	// Every script gets a cancelable context, so it can be stopped by
+	// SCRIPT KILL as well as by its deadline. Checking the context costs a
+	// few nanoseconds per instruction, see BenchmarkLuaContext.
+	var ctx context.Context
+	var cancel context.CancelFunc
 	luaDeadline := lua.LNil
 	if msg.Deadline != nil {
 		dlTime := msg.Deadline.GetDeadlineTime()
-		ctx, cancel := context.WithDeadline(context.Background(), dlTime)
-		defer cancel()
-		luaState.SetContext(ctx)
-		defer luaState.RemoveContext()
+		ctx, cancel = context.WithDeadline(context.Background(), dlTime)
 		luaDeadline = lua.LNumber(float64(dlTime.UnixNano()) / 1e9)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
 	}
-	defer s.luapool.Put(luaState)
+	defer cancel()
+	luaState.SetContext(ctx)
+	defer luaState.RemoveContext()
+	s.luarunning.Add(luaState, cancel)
+	defer s.luarunning.Remove(luaState)
 
 	keysTbl := luaState.CreateTable(int(numkeys), 0)
 	for i = 0; i < numkeys; i++ {
@@ -505,12 +885,22 @@ func (s *Server) cmdEvalUnified(scriptIsSha bool, msg *Message) (res resp.Value,
 	}
 
 	var shaSum string
-	if scriptIsSha {
-		shaSum = script
+	var compiled *lua.FunctionProto
+	if msg.Command() == "evalname" {
+		if compiled, shaSum, ok = s.luascripts.GetTagged(script); !ok {
+			err = errScriptNameNotFound
+			return
+		}
 	} else {
-		shaSum = Sha1Sum(script)
+		if scriptIsSha {
+			shaSum = script
+		} else {
+			shaSum = Sha1Sum(script)
+		}
+		compiled, ok = s.luascripts.Get(shaSum)
 	}
 
+	luaState.G.Registry.RawSetString(luaLastCallElapsed, lua.LNil)
 	luaSetRawGlobals(
 		luaState, map[string]lua.LValue{
 			"KEYS":     keysTbl,
@@ -519,9 +909,9 @@ func (s *Server) cmdEvalUnified(scriptIsSha bool, msg *Message) (res resp.Value,
 			"EVAL_CMD": lua.LString(msg.Command()),
 		})
 
-	compiled, ok := s.luascripts.Get(shaSum)
 	var fn *lua.LFunction
 	if ok {
+		s.statsScriptCacheHits.add(1)
 		fn = &lua.LFunction{
 			IsG: false,
 			Env: luaState.Env,
@@ -538,6 +928,7 @@ func (s *Server) cmdEvalUnified(scriptIsSha bool, msg *Message) (res resp.Value,
 		if err != nil {
 			return NOMessage, makeSafeErr(err)
 		}
+		s.statsScriptCompilations.add(1)
 		s.luascripts.Put(shaSum, fn.Proto)
 	}
 	luaState.Push(fn)
@@ -549,10 +940,13 @@ func (s *Server) cmdEvalUnified(scriptIsSha bool, msg *Message) (res resp.Value,
 			"EVAL_CMD": lua.LNil,
 		})
 	if err := luaState.PCall(0, 1, nil); err != nil {
-		if strings.Contains(err.Error(), "context deadline exceeded") {
-			msg.Deadline.Check()
-		}
 		log.Debugf("%v", err.Error())
+		switch ctx.Err() {
+		case context.DeadlineExceeded:
+			return NOMessage, errScriptTimeout
+		case context.Canceled:
+			return NOMessage, errScriptKilled
+		}
 		return NOMessage, makeSafeErr(err)
 	}
 	ret := luaState.Get(-1) // returned value
@@ -576,10 +970,23 @@ func (s *Server) cmdScriptLoad(msg *Message) (resp.Value, error) {
 	vs := msg.Args[1:]
 
 	var ok bool
-	var script string
+	var script, tag string
 	if vs, script, ok = tokenval(vs); !ok || script == "" {
 		return NOMessage, errInvalidNumberOfArguments
 	}
+	if len(vs) > 0 {
+		var wtok string
+		vs, wtok, _ = tokenval(vs)
+		if strings.ToLower(wtok) != "name" {
+			return NOMessage, errInvalidArgument(wtok)
+		}
+		if vs, tag, ok = tokenval(vs); !ok || tag == "" {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		if len(vs) != 0 {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+	}
 
 	shaSum := Sha1Sum(script)
 
@@ -593,7 +1000,12 @@ func (s *Server) cmdScriptLoad(msg *Message) (resp.Value, error) {
 	if err != nil {
 		return NOMessage, makeSafeErr(err)
 	}
-	s.luascripts.Put(shaSum, fn.Proto)
+	s.statsScriptCompilations.add(1)
+	if tag != "" {
+		s.luascripts.PutTagged(shaSum, tag, fn.Proto)
+	} else {
+		s.luascripts.Put(shaSum, fn.Proto)
+	}
 
 	switch msg.OutputType {
 	case JSON:
@@ -621,6 +1033,10 @@ func (s *Server) cmdScriptExists(msg *Message) (resp.Value, error) {
 			return NOMessage, errInvalidNumberOfArguments
 		}
 		_, ok = s.luascripts.Get(shaSum)
+		if !ok {
+			// also accept tags registered with SCRIPT LOAD ... NAME
+			_, _, ok = s.luascripts.GetTagged(shaSum)
+		}
 		if ok {
 			ires = 1
 		} else {
@@ -666,6 +1082,27 @@ func (s *Server) cmdScriptFlush(msg *Message) (resp.Value, error) {
 	return resp.SimpleStringValue(""), nil
 }
 
+func (s *Server) cmdScriptKill(msg *Message) (resp.Value, error) {
+	start := time.Now()
+	if len(msg.Args) != 1 {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	if err := s.luarunning.Kill(); err != nil {
+		return NOMessage, err
+	}
+
+	switch msg.OutputType {
+	case JSON:
+		var buf bytes.Buffer
+		buf.WriteString(`{"ok":true`)
+		buf.WriteString(`,"elapsed":"` + time.Now().Sub(start).String() + "\"}")
+		return resp.StringValue(buf.String()), nil
+	case RESP:
+		return resp.StringValue("OK"), nil
+	}
+	return resp.SimpleStringValue(""), nil
+}
+
 func (s *Server) commandInScript(msg *Message) (
 	res resp.Value, d commandDetails, err error,
 ) {
@@ -742,13 +1179,17 @@ func (s *Server) luaTile38Call(evalcmd string, cmd string, args ...string) (resp
 		"sethook", "pdelhook", "delhook",
 		"follow", "readonly", "config", "output", "client",
 		"aofshrink",
-		"script load", "script exists", "script flush",
-		"eval", "evalsha", "evalro", "evalrosha", "evalna", "evalnasha":
+		"script load", "script exists", "script flush", "script kill",
+		"eval", "evalsha", "evalro", "evalrosha", "evalna", "evalnasha",
+		"evalname":
+		return resp.NullValue(), errCmdNotSupported
+	}
+	if s.config.scriptCommandDenied(msg.Command()) {
 		return resp.NullValue(), errCmdNotSupported
 	}
 
 	switch evalcmd {
-	case "eval", "evalsha":
+	case "eval", "evalsha", "evalname":
 		return s.luaTile38AtomicRW(msg)
 	case "evalro", "evalrosha":
 		return s.luaTile38AtomicRO(msg)
@@ -759,15 +1200,90 @@ func (s *Server) luaTile38Call(evalcmd string, cmd string, args ...string) (resp
 	return resp.NullValue(), errCmdNotSupported
 }
 
+// luaTile38MSet sets a batch of objects in key and returns how many were
+// set. Every entry is parsed before any is applied, so a bad entry leaves
+// the collection untouched, and non-atomic scripts take the write lock once
+// for the whole batch.
+func (s *Server) luaTile38MSet(evalcmd, key string, entries [][]string) (int, error) {
+	if s.config.scriptCommandDenied("set") {
+		return 0, errCmdNotSupported
+	}
+	switch evalcmd {
+	default:
+		return 0, errCmdNotSupported
+	case "eval", "evalsha", "evalname":
+		// the eval command already holds the write lock
+	case "evalro", "evalrosha":
+		return 0, errReadOnly
+	case "evalna", "evalnasha":
+		defer s.WriterLock()()
+	}
+	if s.config.followHost() != "" {
+		return 0, errNotLeader
+	}
+	if s.config.readOnly() {
+		return 0, errReadOnly
+	}
+
+	msgs := make([]*Message, len(entries))
+	for i, entry := range entries {
+		msg := &Message{}
+		msg.OutputType = RESP
+		msg.Args = append([]string{"set", key}, entry...)
+		if _, _, _, _, _, _, _, _, err := s.parseSetArgs(msg.Args[1:]); err != nil {
+			return 0, fmt.Errorf("entry %d: %v", i+1, err)
+		}
+		msgs[i] = msg
+	}
+
+	var n int
+	for _, msg := range msgs {
+		_, d, err := s.commandInScript(msg)
+		if err != nil {
+			return n, err
+		}
+		if err := s.writeAOF(msg.Args, &d); err != nil {
+			return n, err
+		}
+		if d.updated {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// luaWriteCommands are the commands a script may call that modify the
+// dataset, and luaReadCommands are those that only read it.
+var luaWriteCommands = map[string]bool{
+	"set": true, "del": true, "drop": true, "fset": true, "flushdb": true,
+	"expire": true, "persist": true, "jset": true, "jdel": true, "pdel": true,
+	"rename": true, "renamenx": true,
+}
+var luaReadCommands = map[string]bool{
+	"get": true, "keys": true, "scan": true, "nearby": true, "within": true,
+	"intersects": true, "hooks": true, "search": true, "ttl": true,
+	"bounds": true, "server": true, "info": true, "type": true, "jget": true,
+	"test": true,
+}
+
+// luaWriteCommand returns true when cmd modifies the dataset
+func luaWriteCommand(cmd string) bool {
+	return luaWriteCommands[strings.ToLower(cmd)]
+}
+
+// luaReadOnlyEval returns true when scripts run by evalcmd can't write
+func luaReadOnlyEval(evalcmd string) bool {
+	return evalcmd == "evalro" || evalcmd == "evalrosha"
+}
+
 // The eval command has already got the lock. No locking on the call from within the script.
 func (s *Server) luaTile38AtomicRW(msg *Message) (resp.Value, error) {
 	var write bool
 
-	switch msg.Command() {
+	switch {
 	default:
 		return resp.NullValue(), errCmdNotSupported
-	case "set", "del", "drop", "fset", "flushdb", "expire", "persist", "jset", "pdel",
-		"rename", "renamenx":
+	case luaWriteCommands[msg.Command()]:
 		// write operations
 		write = true
 		if s.config.followHost() != "" {
@@ -776,8 +1292,7 @@ func (s *Server) luaTile38AtomicRW(msg *Message) (resp.Value, error) {
 		if s.config.readOnly() {
 			return resp.NullValue(), errReadOnly
 		}
-	case "get", "keys", "scan", "nearby", "within", "intersects", "hooks", "search",
-		"ttl", "bounds", "server", "info", "type", "jget", "test":
+	case luaReadCommands[msg.Command()]:
 		// read operations
 		if s.config.followHost() != "" && !s.fcuponce {
 			return resp.NullValue(), errCatchingUp
@@ -820,17 +1335,15 @@ func (s *Server) luaTile38AtomicRW(msg *Message) (resp.Value, error) {
 }
 
 func (s *Server) luaTile38AtomicRO(msg *Message) (resp.Value, error) {
-	switch msg.Command() {
+	switch {
 	default:
 		return resp.NullValue(), errCmdNotSupported
 
-	case "set", "del", "drop", "fset", "flushdb", "expire", "persist", "jset", "pdel",
-		"rename", "renamenx":
+	case luaWriteCommands[msg.Command()]:
 		// write operations
 		return resp.NullValue(), errReadOnly
 
-	case "get", "keys", "scan", "nearby", "within", "intersects", "hooks", "search",
-		"ttl", "bounds", "server", "info", "type", "jget", "test":
+	case luaReadCommands[msg.Command()]:
 		// read operations
 		if s.config.followHost() != "" && !s.fcuponce {
 			return resp.NullValue(), errCatchingUp
@@ -865,11 +1378,10 @@ func (s *Server) luaTile38NonAtomic(msg *Message) (resp.Value, error) {
 	var write bool
 
 	// choose the locking strategy
-	switch msg.Command() {
+	switch {
 	default:
 		return resp.NullValue(), errCmdNotSupported
-	case "set", "del", "drop", "fset", "flushdb", "expire", "persist", "jset", "pdel",
-		"rename", "renamenx":
+	case luaWriteCommands[msg.Command()]:
 		// write operations
 		write = true
 		defer s.WriterLock()()
@@ -879,8 +1391,7 @@ func (s *Server) luaTile38NonAtomic(msg *Message) (resp.Value, error) {
 		if s.config.readOnly() {
 			return resp.NullValue(), errReadOnly
 		}
-	case "get", "keys", "scan", "nearby", "within", "intersects", "hooks", "search",
-		"ttl", "bounds", "server", "info", "type", "jget", "test":
+	case luaReadCommands[msg.Command()]:
 		// read operations
 		defer s.ReaderLock()()
 		if s.config.followHost() != "" && !s.fcuponce {
@@ -1009,7 +1520,10 @@ func (s *Server) luaTile38Iterate(coll *luaScanCollector, dl *deadline.Deadline,
 	// Run the scan operation
 	switch cmd {
 	case "nearby":
-		maxDist := lfs.obj.(*geojson.Circle).Meters()
+		maxDist, err := nearbyMaxDistance(lfs.obj)
+		if err != nil {
+			return err
+		}
 		iter := func(id string, o geojson.Object, fields []float64, dist float64) bool {
 			if s.hasExpired(lfs.key, id) {
 				return true
@@ -1072,6 +1586,9 @@ func (s *Server) luaTile38Iterate(coll *luaScanCollector, dl *deadline.Deadline,
 			sc.col.Scan(lfs.desc, sc,
 				dl,
 				func(id string, o geojson.Object, fields []float64) bool {
+					if s.hasExpired(lfs.key, id) {
+						return true
+					}
 					return sc.writeObject(ScanObjectParams{
 						id:     id,
 						o:      o,
@@ -1083,6 +1600,9 @@ func (s *Server) luaTile38Iterate(coll *luaScanCollector, dl *deadline.Deadline,
 			sc.col.ScanRange(g.Limits[0], g.Limits[1], lfs.desc, sc,
 				dl,
 				func(id string, o geojson.Object, fields []float64) bool {
+					if s.hasExpired(lfs.key, id) {
+						return true
+					}
 					return sc.writeObject(ScanObjectParams{
 						id:     id,
 						o:      o,
@@ -1092,7 +1612,8 @@ func (s *Server) luaTile38Iterate(coll *luaScanCollector, dl *deadline.Deadline,
 			)
 		}
 	case "search":
-		if sc.output == outputCount && len(sc.wheres) == 0 && sc.globEverything {
+		if sc.output == outputCount && len(sc.wheres) == 0 && sc.globEverything &&
+			!s.hasKeyExpires(lfs.key) {
 			count := sc.col.Count() - int(lfs.cursor)
 			if count < 0 {
 				count = 0
@@ -1103,6 +1624,9 @@ func (s *Server) luaTile38Iterate(coll *luaScanCollector, dl *deadline.Deadline,
 			if g.Limits[0] == "" && g.Limits[1] == "" {
 				sc.col.SearchValues(lfs.desc, sc, dl,
 					func(id string, o geojson.Object, fields []float64) bool {
+						if s.hasExpired(lfs.key, id) {
+							return true
+						}
 						return sc.writeObject(ScanObjectParams{
 							id:     id,
 							o:      o,
@@ -1118,6 +1642,9 @@ func (s *Server) luaTile38Iterate(coll *luaScanCollector, dl *deadline.Deadline,
 				sc.col.SearchValuesRange(g.Limits[0], g.Limits[1], lfs.desc, sc,
 					dl,
 					func(id string, o geojson.Object, fields []float64) bool {
+						if s.hasExpired(lfs.key, id) {
+							return true
+						}
 						return sc.writeObject(ScanObjectParams{
 							id:     id,
 							o:      o,
@@ -1174,6 +1701,10 @@ const luaGeoJSONObjectTypeName = "geojsonObject"
 const luaScanIteratorTypeName = "scanIterator"
 const luaItemTypeName = "collectionItem"
 
+// luaLastCallElapsed is the registry key holding the duration in seconds of
+// the most recent tile38.call or tile38.pcall in the running script.
+const luaLastCallElapsed = "tile38_last_call_elapsed"
+
 type luaScanIterator struct {
 	sc            *scanner
 	currentParams ScanObjectParams
@@ -1225,6 +1756,21 @@ func registerLuaResultTypes(ls *lua.LState) {
 		return 1
 	})
 
+	// geoSimplify returns a Douglas-Peucker simplification of lines and
+	// polygons. The tolerance is planar, in degrees.
+	geoSimplify := ls.NewFunction(func(ls *lua.LState) int {
+		obj := assertGObject(ls, 1)
+		tolerance := float64(ls.CheckNumber(2))
+		if tolerance < 0 {
+			ls.ArgError(2, "tolerance must not be negative")
+		}
+		ud := ls.NewUserData()
+		ud.Metatable = ls.GetTypeMetatable(luaGeoJSONObjectTypeName)
+		ud.Value = luaSimplify(obj, tolerance)
+		ls.Push(ud)
+		return 1
+	})
+
 	gomt := ls.NewTypeMetatable(luaGeoJSONObjectTypeName)
 	ls.SetFuncs(gomt, map[string]lua.LGFunction{
 		"__tostring": func(ls *lua.LState) int {
@@ -1249,6 +1795,15 @@ func registerLuaResultTypes(ls *lua.LState) {
 				ud.Value = geojson.NewRect(rect)
 				ls.Push(ud)
 				return 1
+			case "bbox":
+				rect := obj.Rect()
+				tbl := ls.CreateTable(4, 0)
+				tbl.Append(lua.LNumber(rect.Min.X))
+				tbl.Append(lua.LNumber(rect.Min.Y))
+				tbl.Append(lua.LNumber(rect.Max.X))
+				tbl.Append(lua.LNumber(rect.Max.Y))
+				ls.Push(tbl)
+				return 1
 			case "center":
 				ud := ls.NewUserData()
 				ud.Metatable = gomt
@@ -1268,12 +1823,30 @@ func registerLuaResultTypes(ls *lua.LState) {
 			case "json":
 				ls.Push(lua.LString(obj.JSON()))
 				return 1
+			case "wkt":
+				wkt, ok := appendWKT(nil, obj)
+				if !ok {
+					ls.RaiseError("no wkt representation for %s", obj.String())
+				}
+				ls.Push(lua.LString(wkt))
+				return 1
 			case "distance":
 				ls.Push(geoDistance)
 				return 1
+			case "simplify":
+				ls.Push(geoSimplify)
+				return 1
 			case "num_points":
 				ls.Push(lua.LNumber(obj.NumPoints()))
 				return 1
+			case "area":
+				area, _ := luaObjectMeasures(obj)
+				ls.Push(lua.LNumber(area))
+				return 1
+			case "perimeter":
+				_, perimeter := luaObjectMeasures(obj)
+				ls.Push(lua.LNumber(perimeter))
+				return 1
 			case "x":
 				if pt, ok := obj.(*geojson.Point); ok {
 					ls.Push(lua.LNumber(pt.Base().X))
@@ -1326,6 +1899,20 @@ func registerLuaResultTypes(ls *lua.LState) {
 		return nargs - 1
 	})
 
+	readAllIteratorFields := ls.NewFunction(func(ls *lua.LState) int {
+		itr := assertIterator(ls, 1)
+		tbl := ls.CreateTable(0, len(itr.sc.fmap))
+		for fn, fi := range itr.sc.fmap {
+			var fieldValue float64
+			if fi < len(itr.currentParams.fields) {
+				fieldValue = itr.currentParams.fields[fi]
+			}
+			tbl.RawSetString(fn, lua.LNumber(fieldValue))
+		}
+		ls.Push(tbl)
+		return 1
+	})
+
 	itrmt := ls.NewTypeMetatable(luaScanIteratorTypeName)
 	ls.SetField(itrmt, "__tostring", ls.NewFunction(func(ls *lua.LState) int {
 		ls.Push(lua.LString("[scanIterator object]"))
@@ -1351,6 +1938,9 @@ func registerLuaResultTypes(ls *lua.LState) {
 			case "read_fields":
 				ls.Push(readIteratorFields)
 				return 1
+			case "read_all_fields":
+				ls.Push(readAllIteratorFields)
+				return 1
 			}
 			ls.RaiseError("unknown property %s", v)
 			return 0
@@ -1393,6 +1983,21 @@ func registerLuaResultTypes(ls *lua.LState) {
 		return nargs - 1
 	})
 
+	allItemFields := ls.NewFunction(func(ls *lua.LState) int {
+		item := assertCollectionItem(ls, 1)
+		fmap := item.col.FieldMap()
+		tbl := ls.CreateTable(0, len(fmap))
+		for fn, fi := range fmap {
+			var fieldValue float64
+			if fi < len(item.fields) {
+				fieldValue = item.fields[fi]
+			}
+			tbl.RawSetString(fn, lua.LNumber(fieldValue))
+		}
+		ls.Push(tbl)
+		return 1
+	})
+
 	itemmt := ls.NewTypeMetatable(luaItemTypeName)
 	ls.SetField(itrmt, "__tostring", ls.NewFunction(func(ls *lua.LState) int {
 		obj := assertCollectionItem(ls, 1)
@@ -1417,6 +2022,9 @@ func registerLuaResultTypes(ls *lua.LState) {
 			case "read_fields":
 				ls.Push(readItemFields)
 				return 1
+			case "all_fields":
+				ls.Push(allItemFields)
+				return 1
 			}
 			ls.RaiseError("unknown property %s", v)
 			return 0
@@ -1424,6 +2032,243 @@ func registerLuaResultTypes(ls *lua.LState) {
 	})
 }
 
+// luaObjectMeasures returns the planar area and perimeter of the polygons
+// and rects in obj, in the units of its coordinates (degrees for lat/lon).
+// Holes are subtracted from the area and added to the perimeter. Circles
+// are measured by the polygon that approximates them. Points and lines have
+// neither.
+func luaObjectMeasures(obj geojson.Object) (area, perimeter float64) {
+	obj.ForEach(func(geom geojson.Object) bool {
+		switch g := geom.(type) {
+		case *geojson.Circle:
+			a, p := luaObjectMeasures(g.Primative())
+			area += a
+			perimeter += p
+		case *geojson.Polygon:
+			poly := g.Base()
+			if poly.Exterior == nil {
+				break
+			}
+			a, p := ringMeasures(poly.Exterior)
+			area += a
+			perimeter += p
+			for _, hole := range poly.Holes {
+				a, p := ringMeasures(hole)
+				area -= a
+				perimeter += p
+			}
+		case *geojson.Rect:
+			rect := g.Base()
+			w, h := rect.Max.X-rect.Min.X, rect.Max.Y-rect.Min.Y
+			area += rect.Area()
+			perimeter += 2 * (w + h)
+		}
+		return true
+	})
+	return area, perimeter
+}
+
+func ringMeasures(ring geometry.Ring) (area, perimeter float64) {
+	for i := 0; i < ring.NumSegments(); i++ {
+		seg := ring.SegmentAt(i)
+		area += seg.A.X*seg.B.Y - seg.B.X*seg.A.Y
+		perimeter += math.Hypot(seg.B.X-seg.A.X, seg.B.Y-seg.A.Y)
+	}
+	return math.Abs(area) / 2, perimeter
+}
+
+// luaSimplify simplifies the points of a line or polygon using the
+// Douglas-Peucker algorithm on planar coordinates. Rings that would be left
+// with fewer than four points are kept as they are. Other objects are
+// returned unchanged.
+func luaSimplify(obj geojson.Object, tolerance float64) geojson.Object {
+	switch g := obj.(type) {
+	case *geojson.LineString:
+		points := simplifyPoints(seriesPoints(g.Base()), tolerance)
+		return geojson.NewLineString(geometry.NewLine(points, nil))
+	case *geojson.Polygon:
+		poly := g.Base()
+		if poly.Exterior == nil {
+			return obj
+		}
+		exterior := simplifyRing(poly.Exterior, tolerance)
+		var holes [][]geometry.Point
+		for _, hole := range poly.Holes {
+			holes = append(holes, simplifyRing(hole, tolerance))
+		}
+		return geojson.NewPolygon(geometry.NewPoly(exterior, holes, nil))
+	}
+	return obj
+}
+
+func seriesPoints(series geometry.Series) []geometry.Point {
+	points := make([]geometry.Point, series.NumPoints())
+	for i := range points {
+		points[i] = series.PointAt(i)
+	}
+	return points
+}
+
+func simplifyRing(ring geometry.Ring, tolerance float64) []geometry.Point {
+	points := seriesPoints(ring)
+	simplified := simplifyPoints(points, tolerance)
+	if len(simplified) < 4 {
+		return points
+	}
+	return simplified
+}
+
+func simplifyPoints(points []geometry.Point, tolerance float64) []geometry.Point {
+	if len(points) < 3 {
+		return points
+	}
+	keep := make([]bool, len(points))
+	keep[0], keep[len(points)-1] = true, true
+	var simplify func(first, last int)
+	simplify = func(first, last int) {
+		var maxDist float64
+		index := -1
+		for i := first + 1; i < last; i++ {
+			dist := segmentDistance(points[i], points[first], points[last])
+			if dist > maxDist {
+				maxDist, index = dist, i
+			}
+		}
+		if index != -1 && maxDist > tolerance {
+			keep[index] = true
+			simplify(first, index)
+			simplify(index, last)
+		}
+	}
+	simplify(0, len(points)-1)
+	var simplified []geometry.Point
+	for i, point := range points {
+		if keep[i] {
+			simplified = append(simplified, point)
+		}
+	}
+	return simplified
+}
+
+// segmentDistance returns the planar distance from p to the segment a-b.
+func segmentDistance(p, a, b geometry.Point) float64 {
+	dx, dy := b.X-a.X, b.Y-a.Y
+	if dx == 0 && dy == 0 {
+		return math.Hypot(p.X-a.X, p.Y-a.Y)
+	}
+	t := ((p.X-a.X)*dx + (p.Y-a.Y)*dy) / (dx*dx + dy*dy)
+	t = math.Max(0, math.Min(1, t))
+	return math.Hypot(p.X-(a.X+t*dx), p.Y-(a.Y+t*dy))
+}
+
+// appendWKT appends the Well-Known Text representation of obj to dst.
+// Rects and circles are written as the polygons they cover. Returns false
+// when obj has no WKT equivalent, such as a string value.
+func appendWKT(dst []byte, obj geojson.Object) ([]byte, bool) {
+	switch g := obj.(type) {
+	case *geojson.Feature:
+		return appendWKT(dst, g.Base())
+	case *geojson.Circle:
+		return appendWKT(dst, g.Primative())
+	case *geojson.Point, *geojson.SimplePoint:
+		dst = append(dst, "POINT "...)
+	case *geojson.LineString:
+		dst = append(dst, "LINESTRING "...)
+	case *geojson.Polygon, *geojson.Rect:
+		dst = append(dst, "POLYGON "...)
+	case *geojson.MultiPoint:
+		dst = append(dst, "MULTIPOINT "...)
+	case *geojson.MultiLineString:
+		dst = append(dst, "MULTILINESTRING "...)
+	case *geojson.MultiPolygon:
+		dst = append(dst, "MULTIPOLYGON "...)
+	case *geojson.GeometryCollection:
+		dst = append(dst, "GEOMETRYCOLLECTION "...)
+	default:
+		return dst, false
+	}
+	if obj.Empty() {
+		return append(dst, "EMPTY"...), true
+	}
+	return appendWKTBody(dst, obj), true
+}
+
+// appendWKTBody appends the parenthesized coordinates of obj, without the
+// geometry type tag.
+func appendWKTBody(dst []byte, obj geojson.Object) []byte {
+	switch g := obj.(type) {
+	case *geojson.Point:
+		dst = append(dst, '(')
+		dst = appendWKTPoint(dst, g.Base())
+		dst = append(dst, ')')
+	case *geojson.SimplePoint:
+		dst = append(dst, '(')
+		dst = appendWKTPoint(dst, g.Base())
+		dst = append(dst, ')')
+	case *geojson.LineString:
+		dst = appendWKTSeries(dst, g.Base(), false)
+	case *geojson.Rect:
+		dst = append(dst, '(')
+		dst = appendWKTSeries(dst, g.Base(), true)
+		dst = append(dst, ')')
+	case *geojson.Polygon:
+		poly := g.Base()
+		dst = append(dst, '(')
+		dst = appendWKTSeries(dst, poly.Exterior, true)
+		for _, hole := range poly.Holes {
+			dst = append(dst, ", "...)
+			dst = appendWKTSeries(dst, hole, true)
+		}
+		dst = append(dst, ')')
+	case *geojson.MultiPoint, *geojson.MultiLineString, *geojson.MultiPolygon:
+		dst = append(dst, '(')
+		for i, child := range g.(interface{ Base() []geojson.Object }).Base() {
+			if i > 0 {
+				dst = append(dst, ", "...)
+			}
+			dst = appendWKTBody(dst, child)
+		}
+		dst = append(dst, ')')
+	case *geojson.GeometryCollection:
+		dst = append(dst, '(')
+		for i, child := range g.Base() {
+			if i > 0 {
+				dst = append(dst, ", "...)
+			}
+			dst, _ = appendWKT(dst, child)
+		}
+		dst = append(dst, ')')
+	}
+	return dst
+}
+
+// appendWKTSeries appends a parenthesized list of points. Rings are closed
+// when the last point doesn't already match the first.
+func appendWKTSeries(dst []byte, series interface {
+	NumPoints() int
+	PointAt(index int) geometry.Point
+}, ring bool) []byte {
+	n := series.NumPoints()
+	dst = append(dst, '(')
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			dst = append(dst, ", "...)
+		}
+		dst = appendWKTPoint(dst, series.PointAt(i))
+	}
+	if ring && n > 0 && series.PointAt(0) != series.PointAt(n-1) {
+		dst = append(dst, ", "...)
+		dst = appendWKTPoint(dst, series.PointAt(0))
+	}
+	return append(dst, ')')
+}
+
+func appendWKTPoint(dst []byte, point geometry.Point) []byte {
+	dst = strconv.AppendFloat(dst, point.X, 'f', -1, 64)
+	dst = append(dst, ' ')
+	return strconv.AppendFloat(dst, point.Y, 'f', -1, 64)
+}
+
 type luaScanCollector struct {
 	ls     *lua.LState
 	f      *lua.LFunction
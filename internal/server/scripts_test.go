@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/geojson"
+	"github.com/tidwall/geojson/geometry"
+	"github.com/tidwall/resp"
+	lua "github.com/yuin/gopher-lua"
+)
+
+func TestAppendWKTCircle(t *testing.T) {
+	circle := geojson.NewCircle(geometry.Point{X: -115, Y: 33}, 1000, 8)
+	wkt, ok := appendWKT(nil, circle)
+	if !ok {
+		t.Fatal("expected wkt for circle")
+	}
+	s := string(wkt)
+	if !strings.HasPrefix(s, "POLYGON ((") || !strings.HasSuffix(s, "))") {
+		t.Fatalf("expected polygon, got %s", s)
+	}
+	points := strings.Split(strings.TrimSuffix(strings.TrimPrefix(s, "POLYGON (("), "))"), ", ")
+	if len(points) < 4 || points[0] != points[len(points)-1] {
+		t.Fatalf("expected closed ring, got %s", s)
+	}
+	wkt, ok = appendWKT(nil, geojson.NewCircle(geometry.Point{X: -115, Y: 33}, 0, 8))
+	if !ok || string(wkt) != "POINT (-115 33)" {
+		t.Fatalf("expected point, got %s", wkt)
+	}
+}
+
+func TestConvertToRESPSpecialTables(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+	convert := func(script string) resp.Value {
+		if err := L.DoString("return " + script); err != nil {
+			t.Fatal(err)
+		}
+		v := ConvertToRESP(L.Get(-1))
+		L.Pop(1)
+		return v
+	}
+	if v := convert("{ok='OK'}"); v.Type() != resp.SimpleString || v.String() != "OK" {
+		t.Fatalf("expected status reply, got %v", v)
+	}
+	if v := convert("{err='boom', code='ERR'}"); v.Type() != resp.Error || v.String() != "boom" {
+		t.Fatalf("expected error reply, got %v", v)
+	}
+	if v := convert("{float=2.5}"); v.Type() != resp.BulkString || v.String() != "2.5" {
+		t.Fatalf("expected float reply, got %v", v)
+	}
+	// a float key next to others is just a field
+	for _, script := range []string{"{float=2.5, name='x'}", "{float=2.5, code='c'}"} {
+		if v := convert(script); v.Type() != resp.Array || len(v.Array()) != 2 {
+			t.Fatalf("expected a table with both keys for %s, got %v", script, v)
+		}
+	}
+	// code only belongs to error tables, other tables keep it
+	if v := convert("{ok='OK', code='c'}"); v.Type() != resp.Array || len(v.Array()) != 2 {
+		t.Fatalf("expected a table with both keys, got %v", v)
+	}
+}
+
+// BenchmarkLuaContext measures the per instruction cost of running a script
+// with a cancelable context, which is what makes it killable.
+func BenchmarkLuaContext(b *testing.B) {
+	const script = `
+		local n = 0
+		for i = 1, 1000 do n = n + i end
+		return n
+	`
+	run := func(b *testing.B, withContext bool) {
+		L := lua.NewState()
+		defer L.Close()
+		fn, err := L.LoadString(script)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if withContext {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			L.SetContext(ctx)
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			L.Push(fn)
+			if err := L.PCall(0, 1, nil); err != nil {
+				b.Fatal(err)
+			}
+			L.Pop(1)
+		}
+	}
+	b.Run("none", func(b *testing.B) { run(b, false) })
+	b.Run("cancel", func(b *testing.B) { run(b, true) })
+}
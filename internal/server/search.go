@@ -3,6 +3,7 @@ package server
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"strconv"
 	"strings"
 	"time"
@@ -378,6 +379,17 @@ func (server *Server) cmdSearchArgs(
 }
 
 var nearbyTypes = []string{"point"}
+
+// nearbyMaxDistance returns the radius in meters of a NEARBY target, which
+// cmdSearchArgs always builds as a circle.
+func nearbyMaxDistance(obj geojson.Object) (float64, error) {
+	circle, ok := obj.(*geojson.Circle)
+	if !ok {
+		return 0, fmt.Errorf("nearby target must be a point, got %T", obj)
+	}
+	return circle.Meters(), nil
+}
+
 var withinOrIntersectsTypes = []string{
 	"geo", "bounds", "hash", "tile", "quadkey", "get", "object", "circle"}
 
@@ -581,7 +593,8 @@ func (server *Server) cmdSearch(msg *Message) (res resp.Value, err error) {
 	}
 	sc.writeHead()
 	if sc.col != nil {
-		if sc.output == outputCount && len(sc.wheres) == 0 && sc.globEverything == true {
+		if sc.output == outputCount && len(sc.wheres) == 0 && sc.globEverything == true &&
+			!server.hasKeyExpires(s.key) {
 			count := sc.col.Count() - int(s.cursor)
 			if count < 0 {
 				count = 0
@@ -592,6 +605,9 @@ func (server *Server) cmdSearch(msg *Message) (res resp.Value, err error) {
 			if g.Limits[0] == "" && g.Limits[1] == "" {
 				sc.col.SearchValues(s.desc, sc, msg.Deadline,
 					func(id string, o geojson.Object, fields []float64) bool {
+						if server.hasExpired(s.key, id) {
+							return true
+						}
 						return sc.writeObject(ScanObjectParams{
 							id:     id,
 							o:      o,
@@ -607,6 +623,9 @@ func (server *Server) cmdSearch(msg *Message) (res resp.Value, err error) {
 				sc.col.SearchValuesRange(g.Limits[0], g.Limits[1], s.desc, sc,
 					msg.Deadline,
 					func(id string, o geojson.Object, fields []float64) bool {
+						if server.hasExpired(s.key, id) {
+							return true
+						}
 						return sc.writeObject(ScanObjectParams{
 							id:     id,
 							o:      o,
@@ -0,0 +1,25 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tidwall/geojson"
+	"github.com/tidwall/geojson/geometry"
+)
+
+func TestNearbyMaxDistance(t *testing.T) {
+	circle := geojson.NewCircle(geometry.Point{X: -115, Y: 33}, 1000, defaultCircleSteps)
+	meters, err := nearbyMaxDistance(circle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meters != 1000 {
+		t.Fatalf("expected 1000, got %v", meters)
+	}
+	point := geojson.NewPoint(geometry.Point{X: -115, Y: 33})
+	_, err = nearbyMaxDistance(point)
+	if err == nil || !strings.Contains(err.Error(), "nearby target must be a point") {
+		t.Fatalf("expected descriptive error, got %v", err)
+	}
+}
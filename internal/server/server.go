@@ -40,6 +40,13 @@ import (
 
 var errOOM = errors.New("OOM command not allowed when used memory > 'maxmemory'")
 
+// errUnknownCommand is returned for commands that the server can't dispatch
+type errUnknownCommand string
+
+func (err errUnknownCommand) Error() string {
+	return fmt.Sprintf("unknown command '%s'", string(err))
+}
+
 func errTimeoutOnCmd(cmd string) error {
 	return fmt.Errorf("timeout not supported for '%s'", cmd)
 }
@@ -78,24 +85,39 @@ type Server struct {
 	config  *Config
 	epc     *endpoint.Manager
 	snapshotMeta *SnapshotMeta
+	snapshotTransport SnapshotTransport
+	snapshotProgress snapshotProgress
 
 	// env opts
 	geomParseOpts geojson.ParseOptions
 	geomIndexOpts geometry.IndexOptions
 
 	// atomics
-	followc            aint // counter increases when follow property changes
-	statsTotalConns    aint // counter for total connections
-	statsTotalCommands aint // counter for total commands
-	statsTotalMsgsSent aint // counter for total sent webhook messages
-	statsExpired       aint // item expiration counter
-	lastShrinkDuration aint
-	stopServer         abool
-	outOfMemory        abool
+	followc                 aint // counter increases when follow property changes
+	statsTotalConns         aint // counter for total connections
+	statsTotalCommands      aint // counter for total commands
+	statsTotalMsgsSent      aint // counter for total sent webhook messages
+	statsExpired            aint // item expiration counter
+	statsScriptCacheHits    aint // counter for scripts run from the script cache
+	statsScriptCompilations aint // counter for compiled scripts
+	statsReplicationLag     aint // bytes the follower is behind the leader
+	lastShrinkDuration      aint
+	stopServer              abool
+	outOfMemory             abool
 
 	connsmu sync.RWMutex
 	conns   map[int]*Client
 
+	cmdstatsmu sync.Mutex
+	cmdstats   map[string]*commandStat // per-command call counters
+
+	expiredmu   sync.Mutex
+	expiredKeys map[string]int // per-collection item expiration counters
+
+	memstatsmu sync.Mutex
+	memstats   runtime.MemStats // latest memstats from the background poller
+	memstatsAt time.Time        // when memstats was last read
+
 	snapmu   sync.Mutex    // snapshot locking
 
 	mu       sync.RWMutex
@@ -123,6 +145,7 @@ type Server struct {
 	aofconnM   map[net.Conn]bool
 	luascripts *lScriptMap
 	luapool    *lStatePool
+	luarunning *lRunningScripts
 
 	pubsub *pubsub
 	hookex expire.List
@@ -155,6 +178,11 @@ func Serve(host string, port int, dir string, http bool) error {
 		conns:    make(map[int]*Client),
 		http:     http,
 		pubsub:   newPubsub(),
+		cmdstats: make(map[string]*commandStat),
+
+		expiredKeys: make(map[string]int),
+
+		snapshotTransport: DefaultSnapshotTransport,
 	}
 
 	server.hookex.Expired = func(item expire.Item) {
@@ -165,8 +193,7 @@ func Serve(host string, port int, dir string, http bool) error {
 	}
 	server.epc = endpoint.NewManager(server)
 	server.luascripts = server.newScriptMap()
-	server.luapool = server.newPool()
-	defer server.luapool.Shutdown()
+	server.luarunning = server.newRunningScripts()
 
 	if err := os.MkdirAll(dir, 0700); err != nil {
 		return err
@@ -176,6 +203,8 @@ func Serve(host string, port int, dir string, http bool) error {
 	if err != nil {
 		return err
 	}
+	server.luapool = server.newPool()
+	defer server.luapool.Shutdown()
 
 	server.snapshotMeta, err = loadSnapshotMeta(filepath.Join(dir, "snapshot_meta"))
 	if err != nil {
@@ -286,12 +315,13 @@ func Serve(host string, port int, dir string, http bool) error {
 		}
 	} else {
 		go server.follow(server.config.followHost(), server.config.followPort(),
-			server.followc.get())
+			server.followc.get(), -1)
 	}
 	go server.processLives()
 	go server.watchOutOfMemory()
 	go server.watchLuaStatePool()
 	go server.watchAutoGC()
+	go server.watchMemStats()
 	go server.backgroundExpiring()
 	go server.backgroundSyncAOF()
 	defer func() {
@@ -805,6 +835,14 @@ func (server *Server) handleInputCommand(client *Client, msg *Message) error {
 		if err := rewriteTimeoutMsg(msg); err != nil {
 			return writeErr(err.Error())
 		}
+	} else {
+		switch msg.Command() {
+		case "nearby", "within", "intersects", "scan", "search":
+			// scans without an explicit timeout use the configured default
+			if timeout := server.config.defaultScanTimeout(); timeout > 0 {
+				msg.Deadline = deadline.New(time.Now().Add(timeout))
+			}
+		}
 	}
 
 	var write bool
@@ -854,7 +892,7 @@ func (server *Server) handleInputCommand(client *Client, msg *Message) error {
 		if server.config.readOnly() {
 			return writeErr("read only")
 		}
-	case "eval", "evalsha":
+	case "eval", "evalsha", "evalname":
 		// write operations (potentially) but no AOF for the script command itself
 		defer server.WriterLock()()
 		if server.config.followHost() != "" {
@@ -865,7 +903,7 @@ func (server *Server) handleInputCommand(client *Client, msg *Message) error {
 		}
 	case "get", "keys", "scan", "nearby", "within", "intersects", "hooks",
 		"chans", "search", "ttl", "bounds", "server", "info", "type", "jget",
-		"evalro", "evalrosha":
+		"evalro", "evalrosha", "debug":
 		// read operations
 
 		defer server.ReaderLock()()
@@ -899,11 +937,16 @@ func (server *Server) handleInputCommand(client *Client, msg *Message) error {
 			}
 		case "load":
 			defer server.WriterLock()()
-		default:  // latest meta is read-only
+		case "status":
+			// status must not wait for the lock held by a save or load
+		default:  // latest meta and list are read-only
 			defer server.ReaderLock()()
 		}
 	case "client":
 		defer server.WriterLock()()
+	case "script":
+		// No locking for script subcommands, the script map has its own lock
+		// and script kill must not wait for the lock held by a running script
 	case "evalna", "evalnasha":
 		// No locking for scripts, otherwise writes cannot happen within scripts
 	case "subscribe", "psubscribe", "publish":
@@ -931,6 +974,11 @@ func (server *Server) handleInputCommand(client *Client, msg *Message) error {
 		}
 		return server.command(msg, client)
 	}()
+	// only count commands that were dispatched, so that unknown commands
+	// can't grow the stats
+	if _, ok := err.(errUnknownCommand); !ok {
+		server.addCommandStat(msg.Command(), time.Since(start))
+	}
 	if res.Type() == resp.Error {
 		return writeErr(res.String())
 	}
@@ -989,7 +1037,7 @@ func (server *Server) command(msg *Message, client *Client) (
 ) {
 	switch msg.Command() {
 	default:
-		err = fmt.Errorf("unknown command '%s'", msg.Args[0])
+		err = errUnknownCommand(msg.Args[0])
 	case "set":
 		res, d, err = server.cmdSet(msg, true)
 	case "fset":
@@ -1030,19 +1078,19 @@ func (server *Server) command(msg *Message, client *Client) (
 		res, err = server.cmdTTL(msg)
 	case "shutdown":
 		if !core.DevMode {
-			err = fmt.Errorf("unknown command '%s'", msg.Args[0])
+			err = errUnknownCommand(msg.Args[0])
 			return
 		}
 		log.Fatal("shutdown requested by developer")
 	case "massinsert":
 		if !core.DevMode {
-			err = fmt.Errorf("unknown command '%s'", msg.Args[0])
+			err = errUnknownCommand(msg.Args[0])
 			return
 		}
 		res, err = server.cmdMassInsert(msg)
 	case "sleep":
 		if !core.DevMode {
-			err = fmt.Errorf("unknown command '%s'", msg.Args[0])
+			err = errUnknownCommand(msg.Args[0])
 			return
 		}
 		res, err = server.cmdSleep(msg)
@@ -1070,6 +1118,8 @@ func (server *Server) command(msg *Message, client *Client) (
 		res, err = server.cmdSearch(msg)
 	case "bounds":
 		res, err = server.cmdBounds(msg)
+	case "debug":
+		res, err = server.cmdDebug(msg)
 	case "get":
 		res, err = server.cmdGet(msg)
 	case "jget":
@@ -1108,7 +1158,7 @@ func (server *Server) command(msg *Message, client *Client) (
 		res, err = server.cmdConfigRewrite(msg)
 	case "config", "script", "snapshot":
 		// These get rewritten into "config foo" and "script bar"
-		err = fmt.Errorf("unknown command '%s'", msg.Args[0])
+		err = errUnknownCommand(msg.Args[0])
 		if len(msg.Args) > 1 {
 			msg.Args[1] = msg.Args[0] + " " + msg.Args[1]
 			msg.Args = msg.Args[1:]
@@ -1119,7 +1169,7 @@ func (server *Server) command(msg *Message, client *Client) (
 		res, err = server.cmdClient(msg, client)
 	case "eval", "evalro", "evalna":
 		res, err = server.cmdEvalUnified(false, msg)
-	case "evalsha", "evalrosha", "evalnasha":
+	case "evalsha", "evalrosha", "evalnasha", "evalname":
 		res, err = server.cmdEvalUnified(true, msg)
 	case "script load":
 		res, err = server.cmdScriptLoad(msg)
@@ -1127,12 +1177,18 @@ func (server *Server) command(msg *Message, client *Client) (
 		res, err = server.cmdScriptExists(msg)
 	case "script flush":
 		res, err = server.cmdScriptFlush(msg)
+	case "script kill":
+		res, err = server.cmdScriptKill(msg)
 	case "snapshot save":
 		res, err = server.cmdSaveSnapshot(msg)
 	case "snapshot load":
 		res, err = server.cmdLoadSnapshot(msg)
 	case "snapshot latest meta":
 		res, err = server.cmdSnapshotLastMeta(msg)
+	case "snapshot list":
+		res, err = server.cmdSnapshotList(msg)
+	case "snapshot status":
+		res, err = server.cmdSnapshotStatus(msg)
 	case "subscribe":
 		res, err = server.cmdSubscribe(msg)
 	case "psubscribe":
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/crc32"
+	"io"
 	"io/ioutil"
 	"math/rand"
 	"os"
@@ -25,6 +27,92 @@ var errSnapshotSaveFailed = errors.New("snapshot save failed")
 var errSnapshotPushFailed = errors.New("snapshot push failed")
 var errSnapshotMetaFailed = errors.New("snapshot meta failed")
 
+// snapshotManifestFile lists the checksum of every collection in a snapshot
+const snapshotManifestFile = "MANIFEST"
+
+// SnapshotTransport moves snapshot dirs between the local disk and the
+// shared snapshot storage.
+type SnapshotTransport interface {
+	// Push uploads the local snapshot dir. It must not return until the
+	// snapshot is fully available to other servers.
+	Push(id, dir string) error
+	// Pull downloads the snapshot into the local dir. It must wait for the
+	// snapshot to become fully available.
+	Pull(id, dir string) error
+}
+
+// DefaultSnapshotTransport is used by new servers. It may be replaced with an
+// in-process implementation before calling Serve.
+var DefaultSnapshotTransport SnapshotTransport = ExecSnapshotTransport{}
+
+// ExecSnapshotTransport runs the push_snapshot and pull_snapshot scripts,
+// which deployment must make available on the system. Each script takes
+// two arguments: the ID string and the source or destination dir.
+type ExecSnapshotTransport struct{}
+
+// Push runs push_snapshot
+func (ExecSnapshotTransport) Push(id, dir string) error {
+	return exec.Command("push_snapshot", id, dir).Run()
+}
+
+// Pull runs pull_snapshot
+func (ExecSnapshotTransport) Pull(id, dir string) error {
+	return exec.Command("pull_snapshot", id, dir).Run()
+}
+
+// saveCollection saves a single collection into a snapshot, and is replaced
+// by tests to inject failures
+var saveCollection = (*collection.Collection).Save
+
+// loadCollection loads a single collection from a snapshot, and is replaced
+// by tests to observe a load in progress
+var loadCollection = (*collection.Collection).Load
+
+// snapshotProgress tracks the most recent snapshot save or load
+type snapshotProgress struct {
+	mu      sync.Mutex
+	op      string // "save" or "load"
+	started time.Time
+	stopped time.Time
+	running abool
+	done    aint // number of collections saved or loaded
+	total   aint // number of collections in the snapshot
+}
+
+func (p *snapshotProgress) begin(op string, total int) {
+	p.mu.Lock()
+	p.op = op
+	p.started = time.Now()
+	p.stopped = time.Time{}
+	p.done.set(0)
+	p.total.set(total)
+	p.running.set(true)
+	p.mu.Unlock()
+}
+
+func (p *snapshotProgress) end() {
+	p.mu.Lock()
+	p.stopped = time.Now()
+	p.running.set(false)
+	p.mu.Unlock()
+}
+
+func (p *snapshotProgress) stats(m map[string]interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	m["in_progress"] = p.running.on()
+	m["operation"] = p.op
+	m["collections_done"] = p.done.get()
+	m["collections_total"] = p.total.get()
+	var elapsed time.Duration
+	if p.running.on() {
+		elapsed = time.Since(p.started)
+	} else if !p.started.IsZero() {
+		elapsed = p.stopped.Sub(p.started)
+	}
+	m["elapsed_seconds"] = elapsed.Seconds()
+}
+
 const (
 	Id     = "id"
 	Offset = "offset"
@@ -113,7 +201,7 @@ func (s *Server) cmdSnapshotLastMeta(msg *Message) (res resp.Value, err error) {
 	case JSON:
 		res = resp.StringValue(
 			fmt.Sprintf(
-				`{"ok":true,"id":"%s","offset":%d,elapsed":"%s"}`,
+				`{"ok":true,"id":"%s","offset":%d,"elapsed":"%s"}`,
 				s.snapshotMeta._idstr,
 				s.snapshotMeta._offset,
 				time.Now().Sub(start)))
@@ -126,6 +214,78 @@ func (s *Server) cmdSnapshotLastMeta(msg *Message) (res resp.Value, err error) {
 	return res, nil
 }
 
+func (s *Server) cmdSnapshotList(msg *Message) (res resp.Value, err error) {
+	start := time.Now()
+	if len(msg.Args) != 1 {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	dirs, err := ioutil.ReadDir(filepath.Join(s.dir, "snapshots"))
+	if err != nil && !os.IsNotExist(err) {
+		log.Errorf("Failed to read snapshots dir: %v", err)
+		return NOMessage, err
+	}
+	snapshots := make([]os.FileInfo, 0, len(dirs))
+	for _, dir := range dirs {
+		if dir.IsDir() {
+			snapshots = append(snapshots, dir)
+		}
+	}
+	sort.Slice(
+		snapshots,
+		func(i, j int) bool {
+			return snapshots[i].ModTime().Before(snapshots[j].ModTime())
+		})
+	switch msg.OutputType {
+	case JSON:
+		ms := make([]map[string]interface{}, 0, len(snapshots))
+		for _, dir := range snapshots {
+			ms = append(ms, map[string]interface{}{
+				"id":       dir.Name(),
+				"modified": dir.ModTime().Unix(),
+				"current":  dir.Name() == s.snapshotMeta._idstr,
+			})
+		}
+		data, err := json.Marshal(ms)
+		if err != nil {
+			return NOMessage, err
+		}
+		res = resp.StringValue(`{"ok":true,"snapshots":` + string(data) +
+			`,"elapsed":"` + time.Now().Sub(start).String() + `"}`)
+	case RESP:
+		vals := make([]resp.Value, 0, len(snapshots))
+		for _, dir := range snapshots {
+			vals = append(vals, resp.ArrayValue([]resp.Value{
+				resp.StringValue(dir.Name()),
+				resp.IntegerValue(int(dir.ModTime().Unix())),
+				resp.IntegerValue(boolInt(dir.Name() == s.snapshotMeta._idstr)),
+			}))
+		}
+		res = resp.ArrayValue(vals)
+	}
+	return res, nil
+}
+
+func (s *Server) cmdSnapshotStatus(msg *Message) (res resp.Value, err error) {
+	start := time.Now()
+	if len(msg.Args) != 1 {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	m := make(map[string]interface{})
+	s.snapshotProgress.stats(m)
+	switch msg.OutputType {
+	case JSON:
+		data, err := json.Marshal(m)
+		if err != nil {
+			return NOMessage, err
+		}
+		res = resp.StringValue(`{"ok":true,"status":` + string(data) +
+			`,"elapsed":"` + time.Now().Sub(start).String() + `"}`)
+	case RESP:
+		res = resp.ArrayValue(respValuesSimpleMap(m))
+	}
+	return res, nil
+}
+
 func (s *Server) getSnapshotDir(snapshotIdStr string) string {
 	return filepath.Join(s.dir, "snapshots", snapshotIdStr)
 }
@@ -140,12 +300,8 @@ func (s *Server) cmdSaveSnapshot(msg *Message) (res resp.Value, err error) {
 	if err := s.doSaveSnapshot(snapshotId, snapshotIdStr, snapshotDir); err != nil {
 		return NOMessage, errSnapshotSaveFailed
 	}
-	// Deployment must make push_snapshot script available on the system.
-	// The script must take two argument: ID string and the source dir.
-	// The script must be able to indicate when snapshot is fully ready in s3.
 	log.Infof("Pushing snapshot %s...", snapshotIdStr)
-	cmd := exec.Command("push_snapshot", snapshotIdStr, snapshotDir)
-	if err := cmd.Run(); err != nil {
+	if err := s.snapshotTransport.Push(snapshotIdStr, snapshotDir); err != nil {
 		log.Errorf("Failed to push snapshot: %v", err)
 		return NOMessage, errSnapshotPushFailed
 	}
@@ -166,7 +322,7 @@ func (s *Server) cmdSaveSnapshot(msg *Message) (res resp.Value, err error) {
 	case JSON:
 		res = resp.StringValue(
 			fmt.Sprintf(
-				`{"ok":true,"id":"%s",elapsed":"%s"}`,
+				`{"ok":true,"id":"%s","elapsed":"%s"}`,
 				snapshotIdStr,
 				time.Now().Sub(start)))
 	case RESP:
@@ -190,29 +346,139 @@ func (s *Server) doSaveSnapshot(snapshotId uint64, snapshotIdStr, snapshotDir st
 			return true
 		})
 
+	s.snapshotProgress.begin("save", len(colByKey))
+	defer s.snapshotProgress.end()
+
 	var wg sync.WaitGroup
+	var errMu sync.Mutex
+	var saveErr error
 	for key, col := range colByKey {
 		colDir := filepath.Join(snapshotDir, key)
 		if err := os.Mkdir(colDir, 0700); err != nil {
 			log.Errorf("Failed to create collection dir: %v", err)
+			wg.Wait()
 			return err
 		}
 		wg.Add(1)
 		go func(c *collection.Collection, k string) {
+			defer wg.Done()
 			log.Infof("Saving collection %s ...", k)
-			if err := c.Save(colDir, snapshotId); err != nil {
+			if err := saveCollection(c, colDir, snapshotId); err != nil {
 				log.Errorf("Collection %s failed: %v", k, err)
+				errMu.Lock()
+				if saveErr == nil {
+					saveErr = err
+				}
+				errMu.Unlock()
 				return
 			}
+			s.snapshotProgress.done.add(1)
 			log.Infof("Collection %s saved", k)
-			wg.Done()
 		}(col, key)
 	}
 	wg.Wait()
+	if saveErr != nil {
+		return errSnapshotSaveFailed
+	}
+	keys := make([]string, 0, len(colByKey))
+	for key := range colByKey {
+		keys = append(keys, key)
+	}
+	if err := writeSnapshotManifest(snapshotDir, keys); err != nil {
+		log.Errorf("Failed to write snapshot manifest: %v", err)
+		return err
+	}
 	log.Infof("Saved snapshot %s", snapshotIdStr)
 	return nil
 }
 
+// checksumCollectionDir returns the CRC32 of the names and contents of all
+// files in a saved collection dir.
+func checksumCollectionDir(colDir string) (uint32, error) {
+	files, err := ioutil.ReadDir(colDir)
+	if err != nil {
+		return 0, err
+	}
+	h := crc32.NewIEEE()
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		h.Write([]byte(file.Name()))
+		if err := hashFile(h, filepath.Join(colDir, file.Name())); err != nil {
+			return 0, err
+		}
+	}
+	return h.Sum32(), nil
+}
+
+// hashFile streams the contents of a file into h, so that large collection
+// files are never read into memory whole.
+func hashFile(h io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(h, f)
+	return err
+}
+
+// writeSnapshotManifest writes the manifest for the collections saved in
+// the snapshot dir.
+func writeSnapshotManifest(snapshotDir string, keys []string) error {
+	m := make(map[string]uint32)
+	for _, key := range keys {
+		sum, err := checksumCollectionDir(filepath.Join(snapshotDir, key))
+		if err != nil {
+			return err
+		}
+		m[key] = sum
+	}
+	data, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	return ioutil.WriteFile(filepath.Join(snapshotDir, snapshotManifestFile), data, 0600)
+}
+
+// verifySnapshotManifest checks that the collections in the snapshot dir are
+// exactly those listed in its manifest, with matching checksums. The
+// manifest is written last, so a snapshot without one is incomplete, unless
+// legacy is set to allow snapshots saved before manifests were written.
+func verifySnapshotManifest(snapshotDir string, keys []string, legacy bool) error {
+	data, err := ioutil.ReadFile(filepath.Join(snapshotDir, snapshotManifestFile))
+	if os.IsNotExist(err) && legacy {
+		log.Warnf("Snapshot %s has no manifest, skipping verification", filepath.Base(snapshotDir))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var m map[string]uint32
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	if len(m) != len(keys) {
+		return fmt.Errorf("manifest lists %d collections, found %d", len(m), len(keys))
+	}
+	for _, key := range keys {
+		expected, ok := m[key]
+		if !ok {
+			return fmt.Errorf("collection %s is not in the manifest", key)
+		}
+		sum, err := checksumCollectionDir(filepath.Join(snapshotDir, key))
+		if err != nil {
+			return err
+		}
+		if sum != expected {
+			return fmt.Errorf("collection %s checksum mismatch", key)
+		}
+	}
+	return nil
+}
+
 func (s *Server) cmdLoadSnapshot(msg *Message) (res resp.Value, err error) {
 	start := time.Now()
 	vs := msg.Args[1:]
@@ -237,11 +503,7 @@ func (s *Server) fetchSnapshot(snapshotIdStr string) (snapshotDir string, err er
 			return
 		}
 		log.Infof("Pulling snapshot %s... (not found locally)", snapshotIdStr)
-		// Deployment must make pull_snapshot script available on the system.
-		// The script must take two argument: ID string and the destination dir.
-		// The script must be able to wait for snapshot to become fully ready in s3.
-		cmd := exec.Command("pull_snapshot", snapshotIdStr, snapshotDir)
-		if err = cmd.Run(); err != nil {
+		if err = s.snapshotTransport.Pull(snapshotIdStr, snapshotDir); err != nil {
 			log.Errorf("Failed to pull snapshot: %v", err)
 			return
 		}
@@ -308,6 +570,12 @@ func (s *Server) doLoadSnapshot(snapshotIdStr string) error {
 			keys = append(keys, dir.Name())
 		}
 	}
+	if err := verifySnapshotManifest(snapshotDir, keys, s.config.snapshotLegacy()); err != nil {
+		log.Errorf("Failed to verify snapshot: %v", err)
+		return errSnapshotLoadFailed
+	}
+	s.snapshotProgress.begin("load", len(keys))
+	defer s.snapshotProgress.end()
 
 	var wg sync.WaitGroup
 	for _, key := range keys {
@@ -317,11 +585,12 @@ func (s *Server) doLoadSnapshot(snapshotIdStr string) error {
 		wg.Add(1)
 		go func(c *collection.Collection, k string) {
 			defer wg.Done()
-			if err := c.Load(colDir, snapshotId, &s.geomParseOpts); err != nil {
+			if err := loadCollection(c, colDir, snapshotId, &s.geomParseOpts); err != nil {
 				log.Errorf("Collection %s failed: %v", k, err)
 				return
 			}
 			s.setCol(k, c)
+			s.snapshotProgress.done.add(1)
 			log.Infof("Collection %s loaded", k)
 		}(col, key)
 	}
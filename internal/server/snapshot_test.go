@@ -0,0 +1,371 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tidwall/geojson"
+	"github.com/tidwall/geojson/geometry"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/tile38/internal/collection"
+	"github.com/tidwall/tinybtree"
+)
+
+func testSnapshotServer(t *testing.T) *Server {
+	dir, err := ioutil.TempDir("", "tile38-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{
+		config:            &Config{},
+		dir:               dir,
+		fcond:             sync.NewCond(&sync.Mutex{}),
+		snapshotMeta:      &SnapshotMeta{path: filepath.Join(dir, "snapshot_meta")},
+		snapshotTransport: &fakeSnapshotTransport{},
+	}
+	for _, key := range []string{"fleet", "trucks"} {
+		col := collection.New()
+		for i := 0; i < 10; i++ {
+			col.Set(strconv.Itoa(i), geojson.NewPoint(
+				geometry.Point{X: float64(i), Y: float64(i)}), nil, nil)
+		}
+		s.setCol(key, col)
+	}
+	return s
+}
+
+// fakeSnapshotTransport records pushes and pulls, and pulls by copying from
+// the dir of the last push
+type fakeSnapshotTransport struct {
+	calls  []string
+	pushed string
+}
+
+func (t *fakeSnapshotTransport) Push(id, dir string) error {
+	t.calls = append(t.calls, "push "+id)
+	t.pushed = dir
+	return nil
+}
+
+func (t *fakeSnapshotTransport) Pull(id, dir string) error {
+	t.calls = append(t.calls, "pull "+id)
+	return filepath.Walk(t.pushed, func(path string, info os.FileInfo, err error) error {
+		if err != nil || path == t.pushed {
+			return err
+		}
+		dst := filepath.Join(dir, path[len(t.pushed):])
+		if info.IsDir() {
+			return os.Mkdir(dst, 0700)
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(dst, data, 0600)
+	})
+}
+
+func TestSnapshotManifest(t *testing.T) {
+	s := testSnapshotServer(t)
+	defer os.RemoveAll(s.dir)
+
+	snapshotId := uint64(0xabc)
+	snapshotIdStr := strconv.FormatUint(snapshotId, 16)
+	snapshotDir := s.getSnapshotDir(snapshotIdStr)
+	if err := s.doSaveSnapshot(snapshotId, snapshotIdStr, snapshotDir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(snapshotDir, snapshotManifestFile)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.doLoadSnapshot(snapshotIdStr); err != nil {
+		t.Fatal(err)
+	}
+
+	// flip a byte in one of the collection files
+	itemsFile := filepath.Join(snapshotDir, "trucks", "itemsData")
+	data, err := ioutil.ReadFile(itemsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xFF
+	if err := ioutil.WriteFile(itemsFile, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.doLoadSnapshot(snapshotIdStr); err != errSnapshotLoadFailed {
+		t.Fatalf("expected '%v', got '%v'", errSnapshotLoadFailed, err)
+	}
+
+	// a collection missing from the manifest also fails
+	data[len(data)-1] ^= 0xFF
+	if err := ioutil.WriteFile(itemsFile, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(filepath.Join(snapshotDir, "fleet")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.doLoadSnapshot(snapshotIdStr); err != errSnapshotLoadFailed {
+		t.Fatalf("expected '%v', got '%v'", errSnapshotLoadFailed, err)
+	}
+}
+
+func TestSnapshotWithoutManifest(t *testing.T) {
+	s := testSnapshotServer(t)
+	defer os.RemoveAll(s.dir)
+
+	// a snapshot that was only partially pushed has no manifest
+	snapshotId := uint64(0x123)
+	snapshotIdStr := strconv.FormatUint(snapshotId, 16)
+	snapshotDir := s.getSnapshotDir(snapshotIdStr)
+	if err := s.doSaveSnapshot(snapshotId, snapshotIdStr, snapshotDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(snapshotDir, snapshotManifestFile)); err != nil {
+		t.Fatal(err)
+	}
+	itemsFile := filepath.Join(snapshotDir, "trucks", "itemsData")
+	data, err := ioutil.ReadFile(itemsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(itemsFile, data[:len(data)/2], 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.doLoadSnapshot(snapshotIdStr); err != errSnapshotLoadFailed {
+		t.Fatalf("expected '%v', got '%v'", errSnapshotLoadFailed, err)
+	}
+
+	// snapshots saved by older versions have no manifest either, and are
+	// only loaded when explicitly allowed
+	if err := ioutil.WriteFile(itemsFile, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.doLoadSnapshot(snapshotIdStr); err != errSnapshotLoadFailed {
+		t.Fatalf("expected '%v', got '%v'", errSnapshotLoadFailed, err)
+	}
+	s.config._snapshotLegacy = "yes"
+	s.cols = tinybtree.BTree{}
+	if err := s.doLoadSnapshot(snapshotIdStr); err != nil {
+		t.Fatal(err)
+	}
+	if s.cols.Len() != 2 || s.getCol("fleet").Count() != 10 {
+		t.Fatalf("expected 2 collections with 10 items each, got %d", s.cols.Len())
+	}
+}
+
+func TestSnapshotSaveFailure(t *testing.T) {
+	s := testSnapshotServer(t)
+	defer os.RemoveAll(s.dir)
+
+	defer func(save func(*collection.Collection, string, uint64) error) {
+		saveCollection = save
+	}(saveCollection)
+	saveCollection = func(c *collection.Collection, dir string, snapshotId uint64) error {
+		if filepath.Base(dir) == "trucks" {
+			return errors.New("injected failure")
+		}
+		return c.Save(dir, snapshotId)
+	}
+
+	snapshotId := uint64(0xdef)
+	snapshotIdStr := strconv.FormatUint(snapshotId, 16)
+	snapshotDir := s.getSnapshotDir(snapshotIdStr)
+	err := s.doSaveSnapshot(snapshotId, snapshotIdStr, snapshotDir)
+	if err != errSnapshotSaveFailed {
+		t.Fatalf("expected '%v', got '%v'", errSnapshotSaveFailed, err)
+	}
+	if _, err := os.Stat(filepath.Join(snapshotDir, snapshotManifestFile)); !os.IsNotExist(err) {
+		t.Fatal("expected no manifest for a failed snapshot")
+	}
+}
+
+func TestSnapshotList(t *testing.T) {
+	s := testSnapshotServer(t)
+	defer os.RemoveAll(s.dir)
+
+	list := func(outputType Type) string {
+		msg := &Message{Args: []string{"snapshot list"}, OutputType: outputType}
+		res, err := s.cmdSnapshotList(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.String()
+	}
+	if res := list(RESP); res != "[]" {
+		t.Fatalf("expected '[]', got '%s'", res)
+	}
+
+	var mtimes []int64
+	for i, snapshotId := range []uint64{0x111, 0x222} {
+		snapshotIdStr := strconv.FormatUint(snapshotId, 16)
+		snapshotDir := s.getSnapshotDir(snapshotIdStr)
+		if err := s.doSaveSnapshot(snapshotId, snapshotIdStr, snapshotDir); err != nil {
+			t.Fatal(err)
+		}
+		mtime := time.Now().Add(time.Duration(i-2) * time.Minute)
+		if err := os.Chtimes(snapshotDir, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		mtimes = append(mtimes, mtime.Unix())
+	}
+	s.snapshotMeta._idstr = "222"
+
+	res := list(RESP)
+	expected := fmt.Sprintf("[[111 %d 0] [222 %d 1]]", mtimes[0], mtimes[1])
+	if res != expected {
+		t.Fatalf("expected '%s', got '%s'", expected, res)
+	}
+	res = list(JSON)
+	snapshots := gjson.Get(res, "snapshots")
+	if !gjson.Get(res, "ok").Bool() || len(snapshots.Array()) != 2 ||
+		snapshots.Get("0.id").String() != "111" ||
+		snapshots.Get("0.current").Bool() ||
+		snapshots.Get("1.id").String() != "222" ||
+		!snapshots.Get("1.current").Bool() {
+		t.Fatalf("unexpected snapshot list '%s'", res)
+	}
+}
+
+func TestSnapshotTransport(t *testing.T) {
+	s := testSnapshotServer(t)
+	defer os.RemoveAll(s.dir)
+	transport := s.snapshotTransport.(*fakeSnapshotTransport)
+
+	res, err := s.cmdSaveSnapshot(&Message{Args: []string{"snapshot save"}, OutputType: RESP})
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshotIdStr := res.String()
+	if s.snapshotMeta._idstr != snapshotIdStr {
+		t.Fatalf("expected snapshot meta id '%s', got '%s'",
+			snapshotIdStr, s.snapshotMeta._idstr)
+	}
+
+	// move the local copy away so that loading has to pull it
+	pushed := transport.pushed
+	transport.pushed = pushed + ".pushed"
+	if err := os.Rename(pushed, transport.pushed); err != nil {
+		t.Fatal(err)
+	}
+	s.cols = tinybtree.BTree{}
+	if err := s.doLoadSnapshot(snapshotIdStr); err != nil {
+		t.Fatal(err)
+	}
+	if s.cols.Len() != 2 || s.getCol("trucks").Count() != 10 {
+		t.Fatalf("expected 2 collections with 10 items each, got %d", s.cols.Len())
+	}
+	expected := []string{"push " + snapshotIdStr, "pull " + snapshotIdStr}
+	if !reflect.DeepEqual(transport.calls, expected) {
+		t.Fatalf("expected calls %v, got %v", expected, transport.calls)
+	}
+}
+
+func TestSnapshotJSONOutput(t *testing.T) {
+	s := testSnapshotServer(t)
+	defer os.RemoveAll(s.dir)
+
+	var out struct {
+		OK      bool   `json:"ok"`
+		ID      string `json:"id"`
+		Offset  int64  `json:"offset"`
+		Elapsed string `json:"elapsed"`
+	}
+	res, err := s.cmdSaveSnapshot(&Message{Args: []string{"snapshot save"}, OutputType: JSON})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(res.String()), &out); err != nil {
+		t.Fatalf("invalid snapshot save output '%s': %v", res.String(), err)
+	}
+	if !out.OK || out.ID != s.snapshotMeta._idstr || out.Elapsed == "" {
+		t.Fatalf("unexpected snapshot save output '%s'", res.String())
+	}
+
+	res, err = s.cmdSnapshotLastMeta(&Message{Args: []string{"snapshot latest meta"}, OutputType: JSON})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(res.String()), &out); err != nil {
+		t.Fatalf("invalid snapshot latest meta output '%s': %v", res.String(), err)
+	}
+	if !out.OK || out.ID != s.snapshotMeta._idstr ||
+		out.Offset != s.snapshotMeta._offset || out.Elapsed == "" {
+		t.Fatalf("unexpected snapshot latest meta output '%s'", res.String())
+	}
+}
+
+func TestSnapshotStatus(t *testing.T) {
+	s := testSnapshotServer(t)
+	defer os.RemoveAll(s.dir)
+
+	status := func() gjson.Result {
+		msg := &Message{Args: []string{"snapshot status"}, OutputType: JSON}
+		res, err := s.cmdSnapshotStatus(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return gjson.Get(res.String(), "status")
+	}
+	if st := status(); st.Get("in_progress").Bool() || st.Get("operation").String() != "" {
+		t.Fatalf("expected no snapshot operation, got '%s'", st)
+	}
+
+	snapshotId := uint64(0x333)
+	snapshotIdStr := strconv.FormatUint(snapshotId, 16)
+	snapshotDir := s.getSnapshotDir(snapshotIdStr)
+	if err := s.doSaveSnapshot(snapshotId, snapshotIdStr, snapshotDir); err != nil {
+		t.Fatal(err)
+	}
+	if st := status(); st.Get("in_progress").Bool() ||
+		st.Get("operation").String() != "save" ||
+		st.Get("collections_done").Int() != 2 ||
+		st.Get("collections_total").Int() != 2 {
+		t.Fatalf("expected a finished save, got '%s'", st)
+	}
+
+	// block the load of one collection
+	defer func(load func(*collection.Collection, string, uint64, *geojson.ParseOptions) error) {
+		loadCollection = load
+	}(loadCollection)
+	release := make(chan struct{})
+	loadCollection = func(c *collection.Collection, dir string, snapshotId uint64, parseOpts *geojson.ParseOptions) error {
+		if filepath.Base(dir) == "trucks" {
+			<-release
+		}
+		return c.Load(dir, snapshotId, parseOpts)
+	}
+	loaded := make(chan error)
+	go func() {
+		loaded <- s.doLoadSnapshot(snapshotIdStr)
+	}()
+	var st gjson.Result
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(time.Millisecond) {
+		st = status()
+		if st.Get("collections_done").Int() == 1 {
+			break
+		}
+	}
+	if !st.Get("in_progress").Bool() ||
+		st.Get("operation").String() != "load" ||
+		st.Get("collections_done").Int() != 1 ||
+		st.Get("collections_total").Int() != 2 {
+		t.Fatalf("expected a load in progress, got '%s'", st)
+	}
+	close(release)
+	if err := <-loaded; err != nil {
+		t.Fatal(err)
+	}
+	if st := status(); st.Get("in_progress").Bool() ||
+		st.Get("collections_done").Int() != 2 {
+		t.Fatalf("expected a finished load, got '%s'", st)
+	}
+}
@@ -9,40 +9,56 @@ import (
 	"sort"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/tidwall/resp"
 	"github.com/tidwall/tile38/core"
 	"github.com/tidwall/tile38/internal/collection"
+	"github.com/tidwall/tile38/internal/glob"
 )
 
-var memStats runtime.MemStats
-var memStatsMu sync.Mutex
-var memStatsBG bool
-
-// ReadMemStats returns the latest memstats. It provides an instant response.
-func readMemStats() runtime.MemStats {
-	memStatsMu.Lock()
-	if !memStatsBG {
-		runtime.ReadMemStats(&memStats)
-		go func() {
-			var ms runtime.MemStats
-			for {
-				runtime.ReadMemStats(&ms)
-				memStatsMu.Lock()
-				memStats = ms
-				memStatsMu.Unlock()
-				time.Sleep(time.Second / 5)
-			}
-		}()
-		memStatsBG = true
+// memStatsInterval is how often the background poller reads memstats
+const memStatsInterval = time.Second / 5
+
+// readMemStats returns the latest memstats. It provides an instant response
+// when the background poller is enabled, otherwise memstats are read on
+// demand.
+func (s *Server) readMemStats() runtime.MemStats {
+	if s.config.memStatsPoller() {
+		s.memstatsmu.Lock()
+		ms, at := s.memstats, s.memstatsAt
+		s.memstatsmu.Unlock()
+		// the poller may have only just been enabled
+		if time.Since(at) < memStatsInterval*5 {
+			return ms
+		}
 	}
-	ms := memStats
-	memStatsMu.Unlock()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
 	return ms
 }
 
+// watchMemStats periodically reads memstats while the memstats-poller
+// config is enabled, so stats commands don't have to stop the world.
+func (s *Server) watchMemStats() {
+	t := time.NewTicker(memStatsInterval)
+	defer t.Stop()
+	var ms runtime.MemStats
+	for range t.C {
+		if s.stopServer.on() {
+			return
+		}
+		if !s.config.memStatsPoller() {
+			continue
+		}
+		runtime.ReadMemStats(&ms)
+		s.memstatsmu.Lock()
+		s.memstats = ms
+		s.memstatsAt = time.Now()
+		s.memstatsmu.Unlock()
+	}
+}
+
 func (s *Server) cmdStats(msg *Message) (res resp.Value, err error) {
 	start := time.Now()
 	vs := msg.Args[1:]
@@ -52,6 +68,24 @@ func (s *Server) cmdStats(msg *Message) (res resp.Value, err error) {
 		return NOMessage, errInvalidNumberOfArguments
 	}
 	var vals []resp.Value
+	appendStats := func(col *collection.Collection) {
+		m := make(map[string]interface{})
+		m["num_points"] = col.PointCount()
+		m["in_memory_size"] = col.TotalWeight()
+		m["num_objects"] = col.Count()
+		m["num_strings"] = col.StringCount()
+		m["num_fields"] = len(col.FieldMap())
+		m["avg_object_size"] = 0.0
+		if n := col.Count(); n > 0 {
+			m["avg_object_size"] = float64(col.TotalWeight()) / float64(n)
+		}
+		switch msg.OutputType {
+		case JSON:
+			ms = append(ms, m)
+		case RESP:
+			vals = append(vals, resp.ArrayValue(respValuesSimpleMap(m)))
+		}
+	}
 	var key string
 	var ok bool
 	for {
@@ -59,19 +93,19 @@ func (s *Server) cmdStats(msg *Message) (res resp.Value, err error) {
 		if !ok {
 			break
 		}
+		if glob.IsGlob(key) {
+			// one stats map for each matching collection, in key order
+			s.cols.Scan(func(ckey string, value interface{}) bool {
+				if match, _ := glob.Match(key, ckey); match {
+					appendStats(value.(*collection.Collection))
+				}
+				return true
+			})
+			continue
+		}
 		col := s.getCol(key)
 		if col != nil {
-			m := make(map[string]interface{})
-			m["num_points"] = col.PointCount()
-			m["in_memory_size"] = col.TotalWeight()
-			m["num_objects"] = col.Count()
-			m["num_strings"] = col.StringCount()
-			switch msg.OutputType {
-			case JSON:
-				ms = append(ms, m)
-			case RESP:
-				vals = append(vals, resp.ArrayValue(respValuesSimpleMap(m)))
-			}
+			appendStats(col)
 		} else {
 			switch msg.OutputType {
 			case JSON:
@@ -160,7 +194,7 @@ func (s *Server) basicStats(m map[string]interface{}) {
 	m["num_points"] = points
 	m["num_objects"] = objects
 	m["num_strings"] = strings
-	mem := readMemStats()
+	mem := s.readMemStats()
 	avgsz := 0
 	if points != 0 {
 		avgsz = int(mem.HeapAlloc) / points
@@ -181,7 +215,7 @@ func (s *Server) basicStats(m map[string]interface{}) {
 // extStats populates the passed map with extended system/go/tile38 statistics
 func (s *Server) extStats(m map[string]interface{}) {
 	n, _ := runtime.ThreadCreateProfile(nil)
-	mem := readMemStats()
+	mem := s.readMemStats()
 
 	// Go/Memory Stats
 
@@ -242,6 +276,8 @@ func (s *Server) extStats(m map[string]interface{}) {
 	// The fraction of this program's available CPU time used by the GC since
 	// the program started
 	m["gc_cpu_fraction"] = mem.GCCPUFraction
+	// Duration of the most recent GC stop-the-world pause in seconds
+	m["gc_pause_seconds"] = float64(mem.PauseNs[(mem.NumGC+255)%256]) / 1e9
 
 	// Tile38 Stats
 
@@ -286,6 +322,8 @@ func (s *Server) extStats(m map[string]interface{}) {
 	}
 	// Total size of the AOF in bytes
 	m["tile38_aof_size"] = s.aofsz
+	// Number of bytes written to the AOF buffer but not yet flushed to disk
+	m["tile38_aof_buffer_bytes"] = len(s.aofbuf)
 	// Whether or no the HTTP transport is being served
 	m["tile38_http_transport"] = s.http
 	// Number of connections accepted by the server
@@ -296,8 +334,18 @@ func (s *Server) extStats(m map[string]interface{}) {
 	m["tile38_total_messages_sent"] = s.statsTotalMsgsSent.get()
 	// Number of key expiration events
 	m["tile38_expired_keys"] = s.statsExpired.get()
+	// Number of key expiration events for each collection
+	m["tile38_collection_expired_keys"] = s.expiredCounts()
 	// Number of connected slaves
 	m["tile38_connected_slaves"] = len(s.aofconnM)
+	// Number of clients that registered a replication port with REPLCONF
+	m["tile38_active_followers"] = s.activeFollowers()
+	// Number of bytes this follower is behind its leader
+	m["tile38_replication_lag_bytes"] = s.statsReplicationLag.get()
+	// Number of scripts that were run from the script cache
+	m["tile38_script_cache_hits"] = s.statsScriptCacheHits.get()
+	// Number of scripts that had to be compiled
+	m["tile38_script_compilations"] = s.statsScriptCompilations.get()
 
 	points := 0
 	objects := 0
@@ -338,6 +386,12 @@ func (s *Server) extStats(m map[string]interface{}) {
 
 	// Total in memory size of all collections
 	m["tile38_in_memory_size"] = sz
+
+	luaTotal, luaIdle := s.luapool.Stats()
+	// Number of lua states in the script interpreter pool
+	m["tile38_lua_states_total"] = luaTotal
+	// Number of lua states in the pool that are not running a script
+	m["tile38_lua_states_idle"] = luaIdle
 }
 
 func (s *Server) writeInfoServer(w *bytes.Buffer) {
@@ -351,7 +405,7 @@ func (s *Server) writeInfoClients(w *bytes.Buffer) {
 	s.connsmu.RUnlock()
 }
 func (s *Server) writeInfoMemory(w *bytes.Buffer) {
-	mem := readMemStats()
+	mem := s.readMemStats()
 	fmt.Fprintf(w, "used_memory:%d\r\n", mem.Alloc) // total number of bytes allocated by Redis using its allocator (either standard libc, jemalloc, or an alternative allocator such as tcmalloc
 }
 func boolInt(t bool) int {
@@ -392,8 +446,12 @@ func (s *Server) writeInfoReplication(w *bytes.Buffer) {
 		s.connsmu.RLock()
 		for _, cc := range s.conns {
 			if cc.replPort != 0 {
+				ip := cc.replAddr
+				if ip == "" {
+					ip = strings.Split(cc.remoteAddr, ":")[0]
+				}
 				fmt.Fprintf(w, "slave%v:ip=%s,port=%v,state=online\r\n", i,
-					strings.Split(cc.remoteAddr, ":")[0], cc.replPort)
+					ip, cc.replPort)
 				i++
 			}
 		}
@@ -402,10 +460,78 @@ func (s *Server) writeInfoReplication(w *bytes.Buffer) {
 	fmt.Fprintf(w, "connected_slaves:%d\r\n", len(s.aofconnM)) // Number of connected slaves
 }
 
+// activeFollowers returns the number of connected clients that announced
+// themselves as followers with REPLCONF listening-port.
+func (s *Server) activeFollowers() int {
+	var n int
+	s.connsmu.RLock()
+	for _, cc := range s.conns {
+		if cc.replPort != 0 {
+			n++
+		}
+	}
+	s.connsmu.RUnlock()
+	return n
+}
+
 func (s *Server) writeInfoCluster(w *bytes.Buffer) {
 	fmt.Fprintf(w, "cluster_enabled:0\r\n")
 }
 
+// commandStat holds the call count and total execution time of a command
+type commandStat struct {
+	calls int64
+	usec  int64
+}
+
+// addCommandStat records a single call of the command
+func (s *Server) addCommandStat(cmd string, elapsed time.Duration) {
+	if cmd == "" {
+		return
+	}
+	s.cmdstatsmu.Lock()
+	stat := s.cmdstats[cmd]
+	if stat == nil {
+		stat = &commandStat{}
+		s.cmdstats[cmd] = stat
+	}
+	stat.calls++
+	stat.usec += int64(elapsed / time.Microsecond)
+	s.cmdstatsmu.Unlock()
+}
+
+// writeInfoCommandstats writes the per-command counters to the 'info' response
+func (s *Server) writeInfoCommandstats(w *bytes.Buffer) {
+	s.cmdstatsmu.Lock()
+	cmds := make([]string, 0, len(s.cmdstats))
+	for cmd := range s.cmdstats {
+		cmds = append(cmds, cmd)
+	}
+	sort.Strings(cmds)
+	for _, cmd := range cmds {
+		stat := s.cmdstats[cmd]
+		fmt.Fprintf(w, "cmdstat_%s:calls=%d,usec=%d,usec_per_call=%.2f\r\n",
+			cmd, stat.calls, stat.usec, float64(stat.usec)/float64(stat.calls))
+	}
+	s.cmdstatsmu.Unlock()
+}
+
+// writeInfoKeyspace writes the collection totals to the 'info' response
+func (s *Server) writeInfoKeyspace(w *bytes.Buffer) {
+	if s.cols.Len() == 0 {
+		return
+	}
+	var objects, points int
+	s.cols.Scan(func(key string, value interface{}) bool {
+		col := value.(*collection.Collection)
+		objects += col.Count()
+		points += col.PointCount()
+		return true
+	})
+	fmt.Fprintf(w, "db0:keys=%d,objects=%d,points=%d\r\n",
+		s.cols.Len(), objects, points)
+}
+
 func (s *Server) cmdInfo(msg *Message) (res resp.Value, err error) {
 	start := time.Now()
 
@@ -457,6 +583,12 @@ func (s *Server) cmdInfo(msg *Message) (res resp.Value, err error) {
 		case "cluster":
 			w.WriteString("# Cluster\r\n")
 			s.writeInfoCluster(w)
+		case "commandstats":
+			w.WriteString("# Commandstats\r\n")
+			s.writeInfoCommandstats(w)
+		case "keyspace":
+			w.WriteString("# Keyspace\r\n")
+			s.writeInfoKeyspace(w)
 		}
 	}
 
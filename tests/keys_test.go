@@ -27,12 +27,15 @@ func subTestKeys(t *testing.T, mc *mockServer) {
 	runStep(t, mc, "PERSIST", keys_PERSIST_test)
 	runStep(t, mc, "SET", keys_SET_test)
 	runStep(t, mc, "STATS", keys_STATS_test)
+	runStep(t, mc, "STATS glob", keys_STATS_glob_test)
+	runStep(t, mc, "STATS avg", keys_STATS_avg_test)
 	runStep(t, mc, "TTL", keys_TTL_test)
 	runStep(t, mc, "SET EX", keys_SET_EX_test)
 	runStep(t, mc, "PDEL", keys_PDEL_test)
 	runStep(t, mc, "FIELDS", keys_FIELDS_test)
 	runStep(t, mc, "WHEREIN", keys_WHEREIN_test)
 	runStep(t, mc, "WHEREEVAL", keys_WHEREEVAL_test)
+	runStep(t, mc, "DEBUG OBJECT", keys_DEBUG_OBJECT_test)
 }
 
 func keys_BOUNDS_test(mc *mockServer) error {
@@ -232,19 +235,58 @@ func keys_STATS_test(mc *mockServer) error {
 	return mc.DoBatch([][]interface{}{
 		{"STATS", "mykey"}, {"[nil]"},
 		{"SET", "mykey", "myid", "STRING", "value"}, {"OK"},
-		{"STATS", "mykey"}, {"[[in_memory_size 9 num_objects 1 num_points 0 num_strings 1]]"},
+		{"STATS", "mykey"}, {"[[avg_object_size 9 in_memory_size 9 num_fields 0 num_objects 1 num_points 0 num_strings 1]]"},
 		{"SET", "mykey", "myid2", "STRING", "value"}, {"OK"},
-		{"STATS", "mykey"}, {"[[in_memory_size 19 num_objects 2 num_points 0 num_strings 2]]"},
+		{"STATS", "mykey"}, {"[[avg_object_size 9.5 in_memory_size 19 num_fields 0 num_objects 2 num_points 0 num_strings 2]]"},
 		{"SET", "mykey", "myid3", "OBJECT", `{"type":"Point","coordinates":[-115,33]}`}, {"OK"},
-		{"STATS", "mykey"}, {"[[in_memory_size 40 num_objects 3 num_points 1 num_strings 2]]"},
+		{"STATS", "mykey"}, {"[[avg_object_size 13.333333333333334 in_memory_size 40 num_fields 0 num_objects 3 num_points 1 num_strings 2]]"},
 		{"DEL", "mykey", "myid"}, {1},
-		{"STATS", "mykey"}, {"[[in_memory_size 31 num_objects 2 num_points 1 num_strings 1]]"},
+		{"STATS", "mykey"}, {"[[avg_object_size 15.5 in_memory_size 31 num_fields 0 num_objects 2 num_points 1 num_strings 1]]"},
 		{"DEL", "mykey", "myid3"}, {1},
-		{"STATS", "mykey"}, {"[[in_memory_size 10 num_objects 1 num_points 0 num_strings 1]]"},
-		{"STATS", "mykey", "mykey2"}, {"[[in_memory_size 10 num_objects 1 num_points 0 num_strings 1] nil]"},
+		{"STATS", "mykey"}, {"[[avg_object_size 10 in_memory_size 10 num_fields 0 num_objects 1 num_points 0 num_strings 1]]"},
+		{"STATS", "mykey", "mykey2"}, {"[[avg_object_size 10 in_memory_size 10 num_fields 0 num_objects 1 num_points 0 num_strings 1] nil]"},
 		{"DEL", "mykey", "myid2"}, {1},
 		{"STATS", "mykey"}, {"[nil]"},
 		{"STATS", "mykey", "mykey2"}, {"[nil nil]"},
+		{"SET", "mykey", "myid", "FIELD", "speed", 10, "POINT", 33, -115}, {"OK"},
+		{"SET", "mykey", "myid2", "FIELD", "heading", 90, "FIELD", "speed", 20, "POINT", 34, -115}, {"OK"},
+		{"SET", "mykey", "myid3", "FIELD", "speed", 30, "POINT", 35, -115}, {"OK"},
+		{"STATS", "mykey"}, {"[[avg_object_size 31.333333333333332 in_memory_size 94 num_fields 2 num_objects 3 num_points 3 num_strings 0]]"},
+	})
+}
+func keys_STATS_avg_test(mc *mockServer) error {
+	if err := mc.DoBatch([][]interface{}{
+		{"SET", "mykey", "a", "STRING", "value"}, {"OK"},
+		{"SET", "mykey", "bb", "POINT", 33, -115}, {"OK"},
+		{"SET", "mykey", "ccc", "FIELD", "speed", 10, "POINT", 33, -115}, {"OK"},
+	}); err != nil {
+		return err
+	}
+	if _, err := mc.Do("OUTPUT", "JSON"); err != nil {
+		return err
+	}
+	res, err := redis.String(mc.Do("STATS", "mykey"))
+	if err != nil {
+		return err
+	}
+	stats := gjson.Get(res, "stats.0")
+	weight, count := stats.Get("in_memory_size").Float(), stats.Get("num_objects").Float()
+	if count != 3 || stats.Get("avg_object_size").Float() != weight/count {
+		return fmt.Errorf("expected avg_object_size of %v, got '%s'", weight/count, stats.Raw)
+	}
+	return nil
+}
+func keys_STATS_glob_test(mc *mockServer) error {
+	return mc.DoBatch([][]interface{}{
+		{"SET", "a", "myid", "STRING", "value"}, {"OK"},
+		{"SET", "b", "myid", "STRING", "value"}, {"OK"},
+		{"SET", "b", "myid2", "STRING", "value"}, {"OK"},
+		{"SET", "ab", "myid", "OBJECT", `{"type":"Point","coordinates":[-115,33]}`}, {"OK"},
+		{"STATS", "a*"}, {"[[avg_object_size 9 in_memory_size 9 num_fields 0 num_objects 1 num_points 0 num_strings 1] [avg_object_size 20 in_memory_size 20 num_fields 0 num_objects 1 num_points 1 num_strings 0]]"},
+		{"STATS", "?"}, {"[[avg_object_size 9 in_memory_size 9 num_fields 0 num_objects 1 num_points 0 num_strings 1] [avg_object_size 9.5 in_memory_size 19 num_fields 0 num_objects 2 num_points 0 num_strings 2]]"},
+		{"STATS", "*b"}, {"[[avg_object_size 20 in_memory_size 20 num_fields 0 num_objects 1 num_points 1 num_strings 0] [avg_object_size 9.5 in_memory_size 19 num_fields 0 num_objects 2 num_points 0 num_strings 2]]"},
+		{"STATS", "c*"}, {"[]"},
+		{"STATS", "b", "c*", "mykey"}, {"[[avg_object_size 9.5 in_memory_size 19 num_fields 0 num_objects 2 num_points 0 num_strings 2] nil]"},
 	})
 }
 func keys_TTL_test(mc *mockServer) error {
@@ -411,3 +453,18 @@ func keys_WHEREEVAL_test(mc *mockServer) error {
 		{"WITHIN", "mykey", "WHEREEVAL", "local a = OBJ:read_fields('a'); return a > tonumber(ARGV[1]) and a ~= tonumber(ARGV[2])", 2, 0.5, 3, "BOUNDS", 32.8, -115.2, 33.2, -114.8}, {`[0 [[myid_a1 {"type":"Point","coordinates":[-115,33]} [a 1]] [myid_a2 {"type":"Point","coordinates":[-115,32.99]} [a 2]]]]`},
 	})
 }
+
+func keys_DEBUG_OBJECT_test(mc *mockServer) error {
+	poly := `{"type":"Polygon","coordinates":[[[-115,33],[-112,33],[-112,34],[-115,34],[-115,33]]]}`
+	return mc.DoBatch([][]interface{}{
+		{"SET", "mykey", "myid1", "OBJECT", poly}, {"OK"},
+		{"DEBUG", "OBJECT", "mykey", "myid1"}, {"[[[33 -115] [34 -112]] 1 [[[33 -115] [34 -112]]]]"},
+		{"SET", "mykey", "myid2", "POINT", 35, -110}, {"OK"},
+		{"DEBUG", "OBJECT", "mykey", "myid2"}, {"[[[35 -110] [35 -110]] 1 [[[33 -115] [35 -110]]]]"},
+		{"SET", "mykey", "str", "STRING", "value"}, {"OK"},
+		{"DEBUG", "OBJECT", "mykey", "str"}, {"ERR id not found"},
+		{"DEBUG", "OBJECT", "mykey", "none"}, {"ERR id not found"},
+		{"DEBUG", "OBJECT", "nokey", "myid1"}, {"ERR key not found"},
+		{"DEBUG", "FOO", "mykey", "myid1"}, {"ERR invalid argument 'FOO'"},
+	})
+}
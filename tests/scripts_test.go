@@ -1,9 +1,17 @@
 package tests
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"math"
 	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/tidwall/gjson"
 )
 
 func subTestScripts(t *testing.T, mc *mockServer) {
@@ -12,6 +20,23 @@ func subTestScripts(t *testing.T, mc *mockServer) {
 	runStep(t, mc, "READONLY", scripts_READONLY_test)
 	runStep(t, mc, "NONATOMIC", scripts_NONATOMIC_test)
 	runStep(t, mc, "ITERATE", scripts_ITERATE_test)
+	runStep(t, mc, "FLOAT", scripts_FLOAT_test)
+	runStep(t, mc, "JSON_ORDER", scripts_JSON_ORDER_test)
+	runStep(t, mc, "POOL", scripts_POOL_test)
+	runStep(t, mc, "KILL", scripts_KILL_test)
+	runStep(t, mc, "EXPIRE", scripts_EXPIRE_test)
+	runStep(t, mc, "COUNT", scripts_COUNT_test)
+	runStep(t, mc, "BINARY", scripts_BINARY_test)
+	runStep(t, mc, "TTL", scripts_TTL_test)
+	runStep(t, mc, "DENYLIST", scripts_DENYLIST_test)
+	runStep(t, mc, "FSET_MANY", scripts_FSET_MANY_test)
+	runStep(t, mc, "ITERATE_CURSOR", scripts_ITERATE_CURSOR_test)
+	runStep(t, mc, "ITERATE_NESTED", scripts_ITERATE_NESTED_test)
+	runStep(t, mc, "WKT", scripts_WKT_test)
+	runStep(t, mc, "SIMPLIFY", scripts_SIMPLIFY_test)
+	runStep(t, mc, "BBOX", scripts_BBOX_test)
+	runStep(t, mc, "LAST_CALL_ELAPSED", scripts_LAST_CALL_ELAPSED_test)
+	runStep(t, mc, "MSET", scripts_MSET_test)
 }
 
 func scripts_BASIC_test(mc *mockServer) error {
@@ -22,6 +47,12 @@ func scripts_BASIC_test(mc *mockServer) error {
 		{"EVALSHA", "2dd1b44209ecb49617af05caf0491390a03c1cc4", "0"}, {"4"},
 		{"SCRIPT FLUSH"}, {"OK"},
 		{"SCRIPT EXISTS", "2dd1b44209ecb49617af05caf0491390a03c1cc4", "no_script"}, {"[0 0]"},
+		{"SCRIPT LOAD", "return 2 + 2", "NAME", "four"}, {"2dd1b44209ecb49617af05caf0491390a03c1cc4"},
+		{"SCRIPT EXISTS", "four", "2dd1b44209ecb49617af05caf0491390a03c1cc4", "no_script"}, {"[1 1 0]"},
+		{"EVALNAME", "four", "0"}, {"4"},
+		{"SCRIPT FLUSH"}, {"OK"},
+		{"SCRIPT EXISTS", "four"}, {"[0]"},
+		{"EVALNAME", "four", "0"}, {"ERR script name not found"},
 		{"EVAL", "return KEYS[1] .. ' only'", 1, "key1"}, {"key1 only"},
 		{"EVAL", "return KEYS[1] .. ' and ' .. ARGV[1]", 1, "key1", "arg1"}, {"key1 and arg1"},
 		{"EVAL", "return ARGV[1] .. ' and ' .. ARGV[2]", 0, "arg1", "arg2"}, {"arg1 and arg2"},
@@ -32,6 +63,31 @@ func scripts_BASIC_test(mc *mockServer) error {
 		{"EVAL", "local obj = tile38.get('mykey', 'myid1').object; return {tostring(obj.x), tostring(obj.y)}", "0"}, {"[-115.1234 33.1234]"},
 		{"EVAL", "return tile38.call('set', KEYS[1], ARGV[1], 'string', 'foobar')", "1", "mykey", "myid2"}, {"OK"},
 		{"EVAL", "local obj = tile38.get('mykey', 'myid2').object; return tostring(obj)", "0"}, {"foobar"},
+		{"SET", "fieldkey", "myid1", "FIELD", "speed", 55, "FIELD", "heading", 270, "POINT", 33, -115}, {"OK"},
+		{"SET", "fieldkey", "myid2", "FIELD", "speed", 10, "POINT", 34, -115}, {"OK"},
+		{"EVAL", "local f = tile38.get('fieldkey', 'myid1'):all_fields(); return {f.speed, f.heading}", "0"}, {"[55 270]"},
+		{"EVAL", "local f = tile38.get('fieldkey', 'myid2'):all_fields(); return {f.speed, f.heading}", "0"}, {"[10 0]"},
+		{"EVAL", `return tile38.validate_geojson('{"type":"Point","coordinates":[-115,33]}')`, "0"}, {"1"},
+		{"EVAL", `local ok, err = tile38.validate_geojson('{"type":"Point"}'); return {ok == false, err}`, "0"}, {"[1 missing coordinates]"},
+		{"EVAL", `local ok, err = tile38.validate_geojson('{"type":'); return {ok == false, err}`, "0"}, {"[1 invalid data]"},
+		{"SET", "areakey", "poly", "OBJECT", `{"type":"Polygon","coordinates":[[[0,0],[4,0],[4,2],[0,2],[0,0]],[[1,1],[2,1],[2,1.5],[1,1.5],[1,1]]]}`}, {"OK"},
+		{"SET", "areakey", "rect", "BOUNDS", 0, 0, 1, 2}, {"OK"},
+		{"EVAL", "local obj = tile38.get_object('areakey', 'poly'); return tile38.float_value(obj.area)", "0"}, {"7.5"},
+		{"EVAL", "local obj = tile38.get_object('areakey', 'poly'); return tile38.float_value(obj.perimeter)", "0"}, {"15"},
+		{"EVAL", "local obj = tile38.get_object('areakey', 'rect'); return {obj.area, obj.perimeter}", "0"}, {"[2 6]"},
+		{"SET", "areakey", "circle", "OBJECT", `{"type":"Feature","geometry":{"type":"Point","coordinates":[0,0]},"properties":{"type":"Circle","radius":1000}}`}, {"OK"},
+		{"EVAL", "local obj = tile38.get_object('areakey', 'circle'); local r = 1000 / 111319.49; " +
+			"return {math.abs(obj.area / (math.pi * r * r) - 1) < 0.05, math.abs(obj.perimeter / (2 * math.pi * r) - 1) < 0.05}", "0"}, {"[1 1]"},
+		{"EVAL", "local obj = tile38.get_object('mykey', 'myid1'); return {obj.area, obj.perimeter}", "0"}, {"[0 0]"},
+		{"EVAL", "tile38.log('debug', 'from eval'); return 'logged'", "0"}, {"logged"},
+		{"EVALRO", "tile38.log('warn', 42); return 'logged'", "0"}, {"logged"},
+		{"EVALNA", "tile38.log('unknown', 'from evalna'); return 'logged'", "0"}, {"logged"},
+		{"EVAL", "local s, us = tile38.time(); return {s > 1500000000, us >= 0 and us < 1000000}", "0"}, {"[1 1]"},
+		{"EVALRO", "local s, us = tile38.time(); return math.abs(s - os.time()) <= 1", "0"}, {"1"},
+		{"EVALNA", "return select('#', tile38.time())", "0"}, {"2"},
+		{"EVAL", "return tile38.get_object('mykey', 'myid3')", "0"}, {nil},
+		{"EVAL", "return tile38.call('set', KEYS[1], ARGV[1], 'point', 33.1234, -115.2234)", "1", "mykey", "myid3"}, {"OK"},
+		{"EVAL", "return tile38.get_object('mykey', 'myid1'):distance(tile38.get_object('mykey', 'myid3'))", "0"}, {"9312"},
 	})
 }
 
@@ -40,6 +96,17 @@ func scripts_ATOMIC_test(mc *mockServer) error {
 		{"EVAL", "return tile38.call('get', KEYS[1], ARGV[1])", "1", "mykey", "myid"}, {nil},
 		{"EVAL", "return tile38.call('set', KEYS[1], ARGV[1], 'point', 33, -115)", "1", "mykey", "myid1"}, {"OK"},
 		{"EVAL", "return tile38.call('get', KEYS[1], ARGV[1], ARGV[2])", "1", "mykey", "myid1", "point"}, {"[33 -115]"},
+		{"EVAL", "return tile38.call('set', {KEYS[1], ARGV[1], 'point', 34, -112})", "1", "mykey", "myid2"}, {"OK"},
+		{"EVAL", "return tile38.call('get', KEYS[1], {ARGV[1], 'point'})", "1", "mykey", "myid2"}, {"[34 -112]"},
+		{"EVAL", "return tile38.pcall('get', {KEYS[1], {}})", "1", "mykey"}, {
+			func(v interface{}) (resp, expect interface{}) {
+				s := fmt.Sprintf("%v", v)
+				if strings.Contains(s, "invalid argument type table") {
+					return v, v
+				}
+				return v, "A lua stack containing 'invalid argument type table'"
+			},
+		},
 	})
 }
 
@@ -56,6 +123,9 @@ func scripts_READONLY_test(mc *mockServer) error {
 			},
 		},
 		{"EVALRO", "return tile38.pcall('set', KEYS[1], ARGV[1], 'point', 33, -115)", "1", "mykey", "myid1"}, {"ERR read only"},
+		{"EVALRO", "return tile38.pcall('set', KEYS[1], ARGV[1], 'point', 33, -115).code", "1", "mykey", "myid1"}, {"READONLY"},
+		{"EVALRO", "return tile38.pcall('flushdb').code", "0"}, {"READONLY"},
+		{"EVALRO", "return tile38.pcall('eval', 'return 1', 0).code", "0"}, {"NOTSUPPORTED"},
 		{"SET", "mykey", "myid1", "POINT", 33, -115}, {"OK"},
 		{"EVALRO", "return tile38.call('get', KEYS[1], ARGV[1], ARGV[2])", "1", "mykey", "myid1", "point"}, {"[33 -115]"},
 	})
@@ -112,6 +182,21 @@ func scripts_ITERATE_test(mc *mockServer) error {
 
 		return {cursor, result}
 	`
+	script_all_fields := `
+        local result = {}
+		local cursor
+
+		local function process(iterator)
+			local fields = iterator:read_all_fields()
+			result[#result + 1] = {iterator.id, fields.foo, fields.bar}
+			return true  -- no early stop, go through all objects
+		end
+
+		cursor = tile38.iterate(
+			process, 'WITHIN', 'key2', 'ids', 'get', 'mykey', 'poly8')
+
+		return {cursor, result}
+	`
 
 	script_nearby_ids := `
         local result = {}
@@ -128,6 +213,12 @@ func scripts_ITERATE_test(mc *mockServer) error {
 		return {cursor, result}
 	`
 
+	script_bad_args := `
+		local ok, err = pcall(tile38.iterate,
+			function(iterator) return true end, 'WITHIN', 'key2', 'bogus')
+		return err
+	`
+
 	poly9 := `{"type":"Polygon","coordinates":[[[-122.44037926197052,37.73313523548048],[-122.44017541408539,37.73313523548048],[-122.44017541408539,37.73336857568778],[-122.44037926197052,37.73336857568778],[-122.44037926197052,37.73313523548048]]]}`
 
 	return mc.DoBatch([][]interface{}{
@@ -143,6 +234,586 @@ func scripts_ITERATE_test(mc *mockServer) error {
 		{"EVAL", script_ids, 0}, {"[1 [poly9]]"}, // early stop, cursor = 1
 		{"EVAL", script_obj, 0}, {"[0 [" + poly9 + "]]"}, // no early stop, cursor = 0
 		{"EVAL", script_fields, 0}, {"[1 [[1 10]]]"}, // early stop, cursor = 1
+		{"EVAL", script_all_fields, 0}, {"[0 [[poly9 1 10]]]"}, // no early stop, cursor = 0
 		{"EVAL", script_nearby_ids, 0}, {"[1 [poly10]]"}, // early stop, cursor = 1
+		{"EVAL", script_bad_args, 0}, {
+			func(v interface{}) (resp, expect interface{}) {
+				s := fmt.Sprintf("%v", v)
+				if strings.Contains(s, ": within: invalid argument 'bogus'") {
+					return v, v
+				}
+				return v, "An error prefixed with 'within: '"
+			},
+		},
+	})
+}
+
+func scripts_FLOAT_test(mc *mockServer) error {
+	return mc.DoBatch([][]interface{}{
+		{"EVAL", "return 2.5", 0}, {"2"},
+		{"EVAL", "return tile38.float_value(2.5)", 0}, {"2.5"},
+		{"EVAL", "return {1, tile38.float_value(2.5)}", 0}, {"[1 2.5]"},
+		{"OUTPUT", "json"}, {`{"ok":true}`},
+		{"EVAL", "return 2.5", 0}, {scriptResult("2.5")},
+		{"EVAL", "return tile38.float_value(2.5)", 0}, {scriptResult("2.5")},
+		{"EVAL", "return {1, tile38.float_value(2.5)}", 0}, {scriptResult("[1,2.5]")},
+		{"OUTPUT", "resp"}, {"OK"},
+	})
+}
+
+func scripts_JSON_ORDER_test(mc *mockServer) error {
+	script := "return {d = 4, b = 2, a = 1, e = {z = true, y = false}, c = 3}"
+	mixed := "local t = {'x', 'y'}; t.b = 2; t.a = 1; return t"
+	batch := [][]interface{}{{"OUTPUT", "json"}, {`{"ok":true}`}}
+	for i := 0; i < 10; i++ {
+		batch = append(batch,
+			[]interface{}{"EVAL", script, 0},
+			[]interface{}{scriptResult(`{"a":1,"b":2,"c":3,"d":4,"e":{"y":false,"z":true}}`)},
+			[]interface{}{"EVAL", mixed, 0},
+			[]interface{}{scriptResult(`["x","y",1,2]`)},
+		)
+	}
+	batch = append(batch, []interface{}{"OUTPUT", "resp"}, []interface{}{"OK"})
+	return mc.DoBatch(batch)
+}
+
+// scriptResult matches the raw "result" member of a JSON script response
+func scriptResult(result string) func(v interface{}) (resp, expect interface{}) {
+	return func(v interface{}) (resp, expect interface{}) {
+		return gjson.Get(fmt.Sprintf("%v", v), "result").Raw, result
+	}
+}
+
+func scripts_POOL_test(mc *mockServer) error {
+	if err := mc.DoBatch([][]interface{}{
+		{"CONFIG", "SET", "lua-pool-max", 0}, {"ERR Invalid argument '0' for CONFIG SET 'lua-pool-max'"},
+		{"CONFIG", "SET", "lua-pool-init", "abc"}, {"ERR Invalid argument 'abc' for CONFIG SET 'lua-pool-init'"},
+		{"CONFIG", "GET", "lua-pool-*"}, {"[lua-pool-init 5 lua-pool-max 1000]"},
+		{"CONFIG", "SET", "lua-pool-max", 10}, {"OK"},
+		{"CONFIG", "SET", "lua-pool-init", 11}, {"ERR Invalid argument '11' for CONFIG SET 'lua-pool-init'"},
+		{"CONFIG", "SET", "lua-pool-max", 4}, {"ERR Invalid argument '4' for CONFIG SET 'lua-pool-max'"},
+		{"CONFIG", "GET", "lua-pool-*"}, {"[lua-pool-init 5 lua-pool-max 10]"},
+		{"CONFIG", "SET", "lua-pool-init", 1}, {"OK"},
+		{"CONFIG", "SET", "lua-pool-max", 1}, {"OK"},
+		{"CONFIG", "SET", "lua-pool-init", ""}, {"ERR Invalid argument '' for CONFIG SET 'lua-pool-init'"},
+		{"CONFIG", "GET", "lua-pool-*"}, {"[lua-pool-init 1 lua-pool-max 1]"},
+	}); err != nil {
+		return err
+	}
+
+	// Returning interpreters to a pool above its maximum shrinks it, so
+	// after enough scripts there is exactly one interpreter left.
+	var cmds [][]interface{}
+	for i := 0; i < 1000; i++ {
+		cmds = append(cmds, []interface{}{"EVALNA", "return 1", 0})
+	}
+	if _, err := mc.DoPipeline(cmds); err != nil {
+		return err
+	}
+
+	// Hold on to the last interpreter with a slow non-atomic script.
+	conn, err := redis.Dial("tcp", fmt.Sprintf(":%d", mc.port))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	sleep := `
+		local function now()
+			local s, us = tile38.time()
+			return s + us / 1e6
+		end
+		local t0 = now()
+		while now() - t0 <= 0.5 do end
+	`
+	var wg sync.WaitGroup
+	var sleepErr error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, sleepErr = conn.Do("EVALNA", sleep, 0)
+	}()
+	time.Sleep(time.Millisecond * 200)
+	res, err := mc.Do("EVALNA", "return 1", 0)
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+	if sleepErr != nil {
+		return sleepErr
+	}
+	if s := fmt.Sprintf("%v", res); s != "ERR no interpreters available" {
+		return fmt.Errorf("expected 'ERR no interpreters available', got '%v'", s)
+	}
+
+	return mc.DoBatch([][]interface{}{
+		{"CONFIG", "SET", "lua-pool-max", 1000}, {"OK"},
+		{"CONFIG", "SET", "lua-pool-init", 5}, {"OK"},
+		{"EVALNA", "return 1", 0}, {"1"},
+	})
+}
+
+func scripts_KILL_test(mc *mockServer) error {
+	// runs a script on its own connection and returns its reply
+	runScript := func(cmd, script string) chan interface{} {
+		ch := make(chan interface{}, 1)
+		go func() {
+			conn, err := redis.Dial("tcp", fmt.Sprintf(":%d", mc.port))
+			if err != nil {
+				ch <- err
+				return
+			}
+			defer conn.Close()
+			res, err := conn.Do(cmd, script, 0)
+			if err != nil {
+				ch <- err
+				return
+			}
+			ch <- res
+		}()
+		return ch
+	}
+	spin := `
+		local function now()
+			local s, us = tile38.time()
+			return s + us / 1e6
+		end
+		local t0 = now()
+		while now() - t0 <= %s do end
+		return 'done'
+	`
+	expect := func(ch chan interface{}, expected string) error {
+		select {
+		case res := <-ch:
+			if s := fmt.Sprintf("%s", res); s != expected {
+				return fmt.Errorf("expected '%s', got '%s'", expected, s)
+			}
+			return nil
+		case <-time.After(time.Second * 10):
+			return fmt.Errorf("expected '%s', got nothing", expected)
+		}
+	}
+
+	if err := mc.DoBatch([][]interface{}{
+		{"SCRIPT", "KILL"}, {"ERR no scripts in execution right now"},
+	}); err != nil {
+		return err
+	}
+
+	// a read only script is killed, and other script subcommands don't
+	// wait for it either
+	ch := runScript("EVAL", fmt.Sprintf(spin, "5"))
+	time.Sleep(time.Millisecond * 200)
+	if err := mc.DoBatch([][]interface{}{
+		{"SCRIPT", "EXISTS", "abc"}, {"[0]"},
+		{"SCRIPT", "KILL"}, {"OK"},
+	}); err != nil {
+		return err
+	}
+	if err := expect(ch, "ERR script killed by user"); err != nil {
+		return err
+	}
+
+	// a script that wrote keeps running
+	ch = runScript("EVALNA", "tile38.call('set', 'killkey', 'id', 'string', 'x')"+
+		fmt.Sprintf(spin, "0.5"))
+	time.Sleep(time.Millisecond * 200)
+	if err := mc.DoBatch([][]interface{}{
+		{"SCRIPT", "KILL"}, {"ERR script already executed write commands and cannot be killed"},
+	}); err != nil {
+		return err
+	}
+	if err := expect(ch, "done"); err != nil {
+		return err
+	}
+
+	// scripts are marked as writers before the write is dispatched, so one
+	// that attempted a write keeps running even if the write failed
+	ch = runScript("EVALNA", "tile38.pcall('set', 'killkey')"+
+		fmt.Sprintf(spin, "0.5"))
+	time.Sleep(time.Millisecond * 200)
+	if err := mc.DoBatch([][]interface{}{
+		{"SCRIPT", "KILL"}, {"ERR script already executed write commands and cannot be killed"},
+	}); err != nil {
+		return err
+	}
+	if err := expect(ch, "done"); err != nil {
+		return err
+	}
+
+	// only the longest running of concurrent read only scripts is killed
+	ch1 := runScript("EVALRO", fmt.Sprintf(spin, "5"))
+	time.Sleep(time.Millisecond * 100)
+	ch2 := runScript("EVALRO", fmt.Sprintf(spin, "0.5"))
+	time.Sleep(time.Millisecond * 100)
+	if err := mc.DoBatch([][]interface{}{
+		{"SCRIPT", "KILL"}, {"OK"},
+	}); err != nil {
+		return err
+	}
+	if err := expect(ch1, "ERR script killed by user"); err != nil {
+		return err
+	}
+	if err := expect(ch2, "done"); err != nil {
+		return err
+	}
+
+	// a script that deleted a json field keeps running
+	if err := mc.DoBatch([][]interface{}{
+		{"JSET", "killkey", "jid", "a", "1"}, {"OK"},
+	}); err != nil {
+		return err
+	}
+	ch = runScript("EVALNA", "tile38.call('jdel', 'killkey', 'jid', 'a')"+
+		fmt.Sprintf(spin, "0.5"))
+	time.Sleep(time.Millisecond * 200)
+	if err := mc.DoBatch([][]interface{}{
+		{"SCRIPT", "KILL"}, {"ERR script already executed write commands and cannot be killed"},
+	}); err != nil {
+		return err
+	}
+	if err := expect(ch, "done"); err != nil {
+		return err
+	}
+	return mc.DoBatch([][]interface{}{
+		{"JGET", "killkey", "jid"}, {"{}"},
+	})
+}
+
+func scripts_EXPIRE_test(mc *mockServer) error {
+	script := `
+		tile38.call('expire', 'mykey', 'a', 0)
+		local ids = {}
+		tile38.iterate(function(iterator)
+			ids[#ids + 1] = iterator.id
+			return true
+		end, 'SCAN', 'mykey')
+		tile38.iterate(function(iterator)
+			ids[#ids + 1] = iterator.id
+			return true
+		end, 'WITHIN', 'mykey', 'BOUNDS', 30, -120, 40, -110)
+		return {
+			ids,
+			tile38.call('scan', 'mykey', 'COUNT'),
+			tile38.call('search', 'mykey', 'COUNT'),
+			tile38.call('get', 'mykey', 'a'),
+		}
+	`
+	return mc.DoBatch([][]interface{}{
+		{"SET", "mykey", "a", "POINT", 33, -115}, {"OK"},
+		{"SET", "mykey", "b", "POINT", 34, -116}, {"OK"},
+		{"EVAL", script, 0}, {"[[b b] 1 0 nil]"},
+		{"SCAN", "mykey", "COUNT"}, {"1"},
+	})
+}
+
+func scripts_COUNT_test(mc *mockServer) error {
+	script := `
+		local count = tile38.call('within', 'mykey', 'COUNT',
+			'BOUNDS', 30, -120, 40, -110)
+		local objs = tile38.call('within', 'mykey', 'IDS',
+			'BOUNDS', 30, -120, 40, -110)
+		return {type(count), count, #objs[2]}
+	`
+	return mc.DoBatch([][]interface{}{
+		{"SET", "mykey", "a", "POINT", 33, -115}, {"OK"},
+		{"SET", "mykey", "b", "POINT", 34, -116}, {"OK"},
+		{"SET", "mykey", "c", "POINT", 35, -117}, {"OK"},
+		{"SET", "mykey", "d", "POINT", 50, -117}, {"OK"},
+		{"EVAL", script, 0}, {"[number 3 3]"},
+	})
+}
+
+func scripts_BINARY_test(mc *mockServer) error {
+	script := `return 'a\0b\255c\r\n'`
+	expected := []byte{'a', 0, 'b', 255, 'c', '\r', '\n'}
+	res, err := mc.Do("EVAL", script, 0)
+	if err != nil {
+		return err
+	}
+	if b, ok := res.([]byte); !ok || !bytes.Equal(b, expected) {
+		return fmt.Errorf("expected %q, got %q", expected, res)
+	}
+	if _, err := mc.Do("OUTPUT", "JSON"); err != nil {
+		return err
+	}
+	res, err = mc.Do("EVAL", script, 0)
+	if err != nil {
+		return err
+	}
+	b, _ := res.([]byte)
+	if !gjson.ValidBytes(b) || !bytes.Contains(b, []byte(`"result":"a\u0000b`)) {
+		return fmt.Errorf("expected escaped JSON, got %q", res)
+	}
+	return nil
+}
+
+func scripts_TTL_test(mc *mockServer) error {
+	script := `
+		local ttl = tile38.call('ttl', 'mykey', 'a')
+		return {
+			type(ttl), ttl > 90 and ttl <= 100,
+			tile38.call('ttl', 'mykey', 'b'),
+			tile38.call('ttl', 'mykey', 'c'),
+		}
+	`
+	return mc.DoBatch([][]interface{}{
+		{"SET", "mykey", "a", "EX", 100, "POINT", 33, -115}, {"OK"},
+		{"SET", "mykey", "b", "POINT", 34, -116}, {"OK"},
+		{"EVAL", script, 0}, {"[number 1 -1 -2]"},
+	})
+}
+
+func scripts_DENYLIST_test(mc *mockServer) error {
+	return mc.DoBatch([][]interface{}{
+		{"SET", "mykey", "myid", "POINT", 33, -115}, {"OK"},
+		{"CONFIG", "SET", "script-command-denylist", "drop, FLUSHDB"}, {"OK"},
+		{"CONFIG", "GET", "script-command-denylist"}, {"[script-command-denylist drop,flushdb]"},
+		{"EVAL", "return tile38.pcall('drop', 'mykey')", 0}, {"ERR command not supported in scripts"},
+		{"EVAL", "return tile38.pcall('flushdb')", 0}, {"ERR command not supported in scripts"},
+		{"EVAL", "return tile38.call('get', 'mykey', 'myid', 'point')", 0}, {"[33 -115]"},
+		{"DROP", "mykey"}, {"1"},
+		{"CONFIG", "SET", "script-command-denylist", ""}, {"OK"},
+		{"EVAL", "return tile38.pcall('drop', 'mykey')", 0}, {"0"},
+	})
+}
+
+func scripts_FSET_MANY_test(mc *mockServer) error {
+	return mc.DoBatch([][]interface{}{
+		{"SET", "mykey", "myid", "POINT", 33, -115}, {"OK"},
+		{"EVAL", "return tile38.fset_many(KEYS[1], ARGV[1], {foo=1, bar=2})", 1, "mykey", "myid"}, {"2"},
+		{"GET", "mykey", "myid", "WITHFIELDS", "POINT"}, {"[[33 -115] [bar 2 foo 1]]"},
+		{"EVAL", "return tile38.fset_many(KEYS[1], ARGV[1], {foo=1, baz=3})", 1, "mykey", "myid"}, {"1"},
+		{"GET", "mykey", "myid", "WITHFIELDS", "POINT"}, {"[[33 -115] [bar 2 baz 3 foo 1]]"},
+		{"EVAL", "return tile38.fset_many(KEYS[1], ARGV[1], {foo='x'})", 1, "mykey", "myid"}, {
+			func(v interface{}) (resp, expect interface{}) {
+				if strings.Contains(fmt.Sprintf("%v", v), "invalid field foo=x") {
+					return v, v
+				}
+				return v, "A lua stack containing 'invalid field foo=x'"
+			},
+		},
+		{"EVALRO", "return tile38.fset_many(KEYS[1], ARGV[1], {foo=5})", 1, "mykey", "myid"}, {
+			func(v interface{}) (resp, expect interface{}) {
+				if strings.Contains(fmt.Sprintf("%v", v), "ERR read only") {
+					return v, v
+				}
+				return v, "A lua stack containing 'ERR read only'"
+			},
+		},
+	})
+}
+
+func scripts_ITERATE_CURSOR_test(mc *mockServer) error {
+	script := `
+		local result = {}
+		local cursor = tile38.iterate(function(iterator)
+			result[#result + 1] = iterator.id
+			return true
+		end, 'SCAN', KEYS[1], 'CURSOR', ARGV[1], 'LIMIT', 2, 'IDS')
+		return {cursor, result}
+	`
+	first := `
+		local result = {}
+		local cursor = tile38.iterate(function(iterator)
+			result[#result + 1] = iterator.id
+			return false  -- early stop, after the first object
+		end, 'SCAN', KEYS[1], 'CURSOR', ARGV[1], 'IDS')
+		return {cursor, result}
+	`
+	return mc.DoBatch([][]interface{}{
+		{"SET", "mykey", "a", "POINT", 33, -115}, {"OK"},
+		{"SET", "mykey", "b", "POINT", 33, -115}, {"OK"},
+		{"SET", "mykey", "c", "POINT", 33, -115}, {"OK"},
+		{"SET", "mykey", "d", "POINT", 33, -115}, {"OK"},
+		{"SET", "mykey", "e", "POINT", 33, -115}, {"OK"},
+		{"EVAL", script, 1, "mykey", 0}, {"[2 [a b]]"},
+		{"EVAL", script, 1, "mykey", 2}, {"[4 [c d]]"},
+		{"EVAL", script, 1, "mykey", 4}, {"[0 [e]]"},
+		{"EVAL", first, 1, "mykey", 0}, {"[1 [a]]"},
+		{"EVAL", first, 1, "mykey", 1}, {"[2 [b]]"},
+	})
+}
+
+func scripts_ITERATE_NESTED_test(mc *mockServer) error {
+	// The callback stalls on the first object so that a concurrent SET is
+	// queued for the write lock. Any nested call that tried to take the
+	// read lock again would then block behind the writer forever.
+	script := `
+		local function now()
+			local s, us = tile38.time()
+			return s + us / 1e6
+		end
+		local result = {}
+		tile38.iterate(function(iterator)
+			if #result == 0 then
+				local t0 = now()
+				while now() - t0 <= 0.3 do end
+			end
+			local count = tile38.call('scan', KEYS[1], 'COUNT')
+			local inner = 0
+			tile38.iterate(function() inner = inner + 1 return true end,
+				'SCAN', KEYS[1], 'IDS')
+			local obj = tile38.get(KEYS[1], iterator.id)
+			result[#result + 1] = string.format('%s:%d:%d:%s',
+				iterator.id, count, inner, tostring(obj ~= nil))
+			return true
+		end, 'SCAN', KEYS[1], 'IDS')
+		return table.concat(result, ' ')
+	`
+	if err := mc.DoBatch([][]interface{}{
+		{"SET", "mykey", "a", "POINT", 33, -115}, {"OK"},
+		{"SET", "mykey", "b", "POINT", 34, -116}, {"OK"},
+	}); err != nil {
+		return err
+	}
+	conn, err := redis.Dial("tcp", fmt.Sprintf(":%d", mc.port))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	type reply struct {
+		s   string
+		err error
+	}
+	ch := make(chan reply, 1)
+	go func() {
+		s, err := redis.String(mc.Do("EVALNA", script, 1, "mykey"))
+		ch <- reply{s, err}
+	}()
+	time.Sleep(time.Millisecond * 100)
+	go conn.Do("SET", "otherkey", "c", "POINT", 35, -117)
+	select {
+	case r := <-ch:
+		if r.err != nil {
+			return r.err
+		}
+		expected := "a:2:2:true b:2:2:true"
+		if r.s != expected {
+			return fmt.Errorf("expected '%s', got '%s'", expected, r.s)
+		}
+	case <-time.After(time.Second * 5):
+		return errors.New("nested scan deadlocked")
+	}
+	return nil
+}
+
+func scripts_WKT_test(mc *mockServer) error {
+	wkt := func(id string) string {
+		return fmt.Sprintf("return tile38.get_object('mykey', '%s').wkt", id)
+	}
+	return mc.DoBatch([][]interface{}{
+		{"SET", "mykey", "point", "POINT", 33, -115.5}, {"OK"},
+		{"SET", "mykey", "line", "OBJECT", `{"type":"LineString","coordinates":[[0,0],[1,2]]}`}, {"OK"},
+		{"SET", "mykey", "poly", "OBJECT", `{"type":"Polygon","coordinates":[[[0,0],[4,0],[4,2],[0,2],[0,0]],[[1,1],[2,1],[2,1.5],[1,1.5],[1,1]]]}`}, {"OK"},
+		{"SET", "mykey", "rect", "BOUNDS", 0, 0, 1, 2}, {"OK"},
+		{"SET", "mykey", "multi", "OBJECT", `{"type":"MultiPoint","coordinates":[[0,0],[1,2]]}`}, {"OK"},
+		{"SET", "mykey", "feature", "OBJECT", `{"type":"Feature","geometry":{"type":"Point","coordinates":[1,2]},"properties":{}}`}, {"OK"},
+		{"SET", "mykey", "str", "STRING", "hello"}, {"OK"},
+		{"EVAL", wkt("point"), 0}, {"POINT (-115.5 33)"},
+		{"EVAL", wkt("line"), 0}, {"LINESTRING (0 0, 1 2)"},
+		{"EVAL", wkt("poly"), 0}, {"POLYGON ((0 0, 4 0, 4 2, 0 2, 0 0), (1 1, 2 1, 2 1.5, 1 1.5, 1 1))"},
+		{"EVAL", wkt("rect"), 0}, {"POLYGON ((0 0, 2 0, 2 1, 0 1, 0 0))"},
+		{"EVAL", wkt("multi"), 0}, {"MULTIPOINT ((0 0), (1 2))"},
+		{"EVAL", wkt("feature"), 0}, {"POINT (1 2)"},
+		{"EVAL", "return tile38.get_object('mykey', 'poly').wkt:sub(1, 7)", 0}, {"POLYGON"},
+		{"EVAL", "return tile38.get_object('mykey', 'poly').rect.wkt", 0}, {"POLYGON ((0 0, 4 0, 4 2, 0 2, 0 0))"},
+		{"EVAL", "return tile38.get_object('mykey', 'poly').center.wkt", 0}, {"POINT (2 1)"},
+		{"EVAL", wkt("str"), 0}, {
+			func(v interface{}) (resp, expect interface{}) {
+				if strings.Contains(fmt.Sprintf("%v", v), "no wkt representation for hello") {
+					return v, v
+				}
+				return v, "A lua stack containing 'no wkt representation for hello'"
+			},
+		},
+	})
+}
+
+func scripts_SIMPLIFY_test(mc *mockServer) error {
+	// a dense, slightly jagged circle around 0,0
+	var coords []string
+	for i := 0; i < 200; i++ {
+		r := 1.0
+		if i%2 == 1 {
+			r = 0.999
+		}
+		th := float64(i) / 200 * 2 * math.Pi
+		coords = append(coords, fmt.Sprintf("[%f,%f]", r*math.Cos(th), r*math.Sin(th)))
+	}
+	coords = append(coords, coords[0])
+	dense := `{"type":"Polygon","coordinates":[[` + strings.Join(coords, ",") + `]]}`
+	script := `
+		local obj = tile38.get_object('mykey', 'dense')
+		local simple = obj:simplify(0.01)
+		return {obj.num_points, simple.num_points < obj.num_points / 4,
+			simple:contains(obj.center)}
+	`
+	return mc.DoBatch([][]interface{}{
+		{"SET", "mykey", "dense", "OBJECT", dense}, {"OK"},
+		{"EVAL", script, 0}, {"[201 1 1]"},
+		{"EVAL", "return tile38.get_object('mykey', 'dense'):simplify(0).num_points", 0}, {"201"},
+		{"SET", "mykey", "line", "OBJECT", `{"type":"LineString","coordinates":[[0,0],[1,0.01],[2,0],[3,1]]}`}, {"OK"},
+		{"EVAL", "return tile38.get_object('mykey', 'line'):simplify(0.1).wkt", 0}, {"LINESTRING (0 0, 2 0, 3 1)"},
+		{"EVAL", "return tile38.get_object('mykey', 'line'):simplify(0.001).wkt", 0}, {"LINESTRING (0 0, 1 0.01, 2 0, 3 1)"},
+		{"SET", "mykey", "point", "POINT", 33, -115}, {"OK"},
+		{"EVAL", "return tile38.get_object('mykey', 'point'):simplify(1).wkt", 0}, {"POINT (-115 33)"},
+	})
+}
+
+func scripts_BBOX_test(mc *mockServer) error {
+	return mc.DoBatch([][]interface{}{
+		{"SET", "mykey", "poly", "OBJECT", `{"type":"Polygon","coordinates":[[[-115,33],[-112.5,33],[-112,34.5],[-115,34],[-115,33]]]}`}, {"OK"},
+		{"SET", "mykey", "point", "POINT", 33, -115}, {"OK"},
+		{"EVAL", "return tile38.float_value(tile38.get_object('mykey', 'poly').bbox[3])", 0}, {"-112"},
+		{"EVAL", "local b = tile38.get_object('mykey', 'poly').bbox; return {#b, b[1], b[2], tostring(b[3]), tostring(b[4])}", 0}, {"[4 -115 33 -112 34.5]"},
+		{"EVAL", "return tile38.get_object('mykey', 'point').bbox", 0}, {"[-115 33 -115 33]"},
+	})
+}
+
+func scripts_LAST_CALL_ELAPSED_test(mc *mockServer) error {
+	script := `
+		local before = tile38.last_call_elapsed()
+		tile38.call('set', KEYS[1], 'myid', 'point', 33, -115)
+		local elapsed = tile38.last_call_elapsed()
+		return {before == 0, elapsed > 0, elapsed < 1}
+	`
+	return mc.DoBatch([][]interface{}{
+		{"EVAL", script, 1, "mykey"}, {"[1 1 1]"},
+		// the previous script's call doesn't leak into the next one
+		{"EVAL", "return tile38.last_call_elapsed() == 0", 0}, {"1"},
+		{"EVALRO", "tile38.pcall('set', 'mykey', 'myid', 'point', 1, 2); return tile38.last_call_elapsed() > 0", 0}, {"1"},
+	})
+}
+
+func scripts_MSET_test(mc *mockServer) error {
+	script := `
+		local entries = {}
+		for i = 1, 100 do
+			entries[i] = {'id' .. i, 'FIELD', 'speed', i, 'POINT', 33, -115 + i / 1000}
+		end
+		return tile38.mset(KEYS[1], entries)
+	`
+	errContains := func(msg string) func(v interface{}) (resp, expect interface{}) {
+		return func(v interface{}) (resp, expect interface{}) {
+			if strings.Contains(fmt.Sprintf("%v", v), msg) {
+				return v, v
+			}
+			return v, "A lua stack containing '" + msg + "'"
+		}
+	}
+	return mc.DoBatch([][]interface{}{
+		{"EVAL", script, 1, "mykey"}, {"100"},
+		{"SCAN", "mykey", "COUNT"}, {"100"},
+		{"GET", "mykey", "id42", "WITHFIELDS", "POINT"}, {"[[33 -114.958] [speed 42]]"},
+		{"EVALNA", script, 1, "otherkey"}, {"100"},
+		{"SCAN", "otherkey", "COUNT"}, {"100"},
+		{"EVAL", "return tile38.mset(KEYS[1], {{'a', 'POINT', 33, -115}, {'b', 'POINT', 33}})", 1, "badkey"}, {
+			errContains("entry 2: invalid number of arguments"),
+		},
+		{"SCAN", "badkey", "COUNT"}, {"0"},
+		{"EVALRO", script, 1, "rokey"}, {errContains("ERR read only")},
+		{"EVAL", "return tile38.mset(KEYS[1], {'a'})", 1, "badkey"}, {
+			errContains("invalid entry 1, expected a table"),
+		},
 	})
 }
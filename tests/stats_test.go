@@ -2,13 +2,30 @@ package tests
 
 import (
 	"errors"
+	"fmt"
+	"net/http"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/gomodule/redigo/redis"
 	"github.com/tidwall/gjson"
 )
 
 func subTestInfo(t *testing.T, mc *mockServer) {
 	runStep(t, mc, "valid json", info_valid_json_test)
+	runStep(t, mc, "server ext", info_server_ext_test)
+	runStep(t, mc, "server ext gc", info_server_ext_gc_test)
+	runStep(t, mc, "script cache stats", info_script_cache_stats_test)
+	runStep(t, mc, "keyspace", info_keyspace_test)
+	runStep(t, mc, "commandstats", info_commandstats_test)
+	runStep(t, mc, "cpu", info_cpu_test)
+	runStep(t, mc, "expired keys", info_expired_keys_test)
+	runStep(t, mc, "expired keys pruned", info_expired_keys_pruned_test)
+	runStep(t, mc, "replication", info_replication_test)
+	runStep(t, mc, "aof buffer", info_aof_buffer_test)
+	runStep(t, mc, "active followers", info_active_followers_test)
+	runStep(t, mc, "memstats poller", info_memstats_poller_test)
 }
 
 func info_valid_json_test(mc *mockServer) error {
@@ -33,3 +50,489 @@ func info_valid_json_test(mc *mockServer) error {
 	}
 	return nil
 }
+
+func info_server_ext_test(mc *mockServer) error {
+	if _, err := mc.Do("OUTPUT", "JSON"); err != nil {
+		return err
+	}
+	res, err := mc.Do("SERVER", "EXT")
+	if err != nil {
+		return err
+	}
+	bres, ok := res.([]byte)
+	if !ok {
+		return errors.New("Failed to type assert SERVER EXT response")
+	}
+	stats := gjson.GetBytes(bres, "stats")
+	total := stats.Get("tile38_lua_states_total")
+	idle := stats.Get("tile38_lua_states_idle")
+	if !total.Exists() || !idle.Exists() {
+		return errors.New("SERVER EXT response is missing the lua pool stats")
+	}
+	if total.Int() < 1 || idle.Int() > total.Int() {
+		return fmt.Errorf("unexpected lua pool stats: total %d, idle %d",
+			total.Int(), idle.Int())
+	}
+	// the test server is a leader, so it never lags
+	if lag := stats.Get("tile38_replication_lag_bytes"); !lag.Exists() || lag.Int() != 0 {
+		return fmt.Errorf("expected no replication lag, got '%s'", lag.Raw)
+	}
+	return nil
+}
+
+func info_server_ext_gc_test(mc *mockServer) error {
+	if _, err := mc.Do("OUTPUT", "JSON"); err != nil {
+		return err
+	}
+	res, err := mc.Do("SERVER", "EXT")
+	if err != nil {
+		return err
+	}
+	bres, ok := res.([]byte)
+	if !ok {
+		return errors.New("Failed to type assert SERVER EXT response")
+	}
+	stats := gjson.GetBytes(bres, "stats")
+	goroutines := stats.Get("go_goroutines")
+	pause := stats.Get("gc_pause_seconds")
+	fraction := stats.Get("gc_cpu_fraction")
+	if !goroutines.Exists() || !pause.Exists() || !fraction.Exists() {
+		return errors.New("SERVER EXT response is missing the runtime stats")
+	}
+	if goroutines.Int() < 1 || pause.Float() < 0 ||
+		fraction.Float() < 0 || fraction.Float() > 1 {
+		return fmt.Errorf("unexpected runtime stats: goroutines %d, "+
+			"gc pause %v, gc cpu fraction %v",
+			goroutines.Int(), pause.Float(), fraction.Float())
+	}
+	return nil
+}
+
+func info_script_cache_stats_test(mc *mockServer) error {
+	if _, err := mc.Do("OUTPUT", "JSON"); err != nil {
+		return err
+	}
+	stats := func() (hits, compilations int64, err error) {
+		res, err := mc.Do("SERVER", "EXT")
+		if err != nil {
+			return 0, 0, err
+		}
+		bres, ok := res.([]byte)
+		if !ok {
+			return 0, 0, errors.New("Failed to type assert SERVER EXT response")
+		}
+		return gjson.GetBytes(bres, "stats.tile38_script_cache_hits").Int(),
+			gjson.GetBytes(bres, "stats.tile38_script_compilations").Int(), nil
+	}
+	hits0, compilations0, err := stats()
+	if err != nil {
+		return err
+	}
+	// SCRIPT LOAD compiles the script, after which EVALSHA uses the cache
+	res, err := mc.Do("SCRIPT", "LOAD", "return 'script cache stats'")
+	if err != nil {
+		return err
+	}
+	sha := gjson.GetBytes(res.([]byte), "result").String()
+	for i := 0; i < 2; i++ {
+		if _, err := mc.Do("EVALSHA", sha, 0); err != nil {
+			return err
+		}
+	}
+	hits1, compilations1, err := stats()
+	if err != nil {
+		return err
+	}
+	if hits1-hits0 != 2 || compilations1-compilations0 != 1 {
+		return fmt.Errorf("expected 2 cache hits and 1 compilation, got %d and %d",
+			hits1-hits0, compilations1-compilations0)
+	}
+	return nil
+}
+
+func info_keyspace_test(mc *mockServer) error {
+	if err := mc.DoBatch([][]interface{}{
+		{"SET", "fleet", "truck1", "POINT", 33, -115}, {"OK"},
+		{"SET", "fleet", "truck2", "POINT", 34, -116}, {"OK"},
+		{"SET", "names", "name1", "STRING", "tom"}, {"OK"},
+	}); err != nil {
+		return err
+	}
+	res, err := mc.Do("INFO", "keyspace")
+	if err != nil {
+		return err
+	}
+	bres, ok := res.([]byte)
+	if !ok {
+		return errors.New("Failed to type assert INFO response")
+	}
+	line := "db0:keys=2,objects=3,points=2\r\n"
+	if !strings.Contains(string(bres), "# Keyspace\r\n"+line) {
+		return fmt.Errorf("expected '%s' in INFO keyspace, got '%s'",
+			strings.TrimSpace(line), bres)
+	}
+	return nil
+}
+
+func info_commandstats_test(mc *mockServer) error {
+	calls := func(cmd string) (int, error) {
+		res, err := mc.Do("INFO", "commandstats")
+		if err != nil {
+			return 0, err
+		}
+		bres, ok := res.([]byte)
+		if !ok {
+			return 0, errors.New("Failed to type assert INFO response")
+		}
+		prefix := "cmdstat_" + cmd + ":calls="
+		for _, line := range strings.Split(string(bres), "\r\n") {
+			if strings.HasPrefix(line, prefix) {
+				var n int
+				fmt.Sscanf(line[len(prefix):], "%d", &n)
+				return n, nil
+			}
+		}
+		return 0, nil
+	}
+	sets0, err := calls("set")
+	if err != nil {
+		return err
+	}
+	gets0, err := calls("get")
+	if err != nil {
+		return err
+	}
+	if err := mc.DoBatch([][]interface{}{
+		{"SET", "fleet", "truck1", "POINT", 33, -115}, {"OK"},
+		{"SET", "fleet", "truck2", "POINT", 34, -116}, {"OK"},
+		{"SET", "fleet", "truck3", "POINT", 35, -117}, {"OK"},
+		{"GET", "fleet", "truck1", "POINT"}, {"[33 -115]"},
+		{"GET", "fleet", "truck2", "POINT"}, {"[34 -116]"},
+	}); err != nil {
+		return err
+	}
+	sets1, err := calls("set")
+	if err != nil {
+		return err
+	}
+	gets1, err := calls("get")
+	if err != nil {
+		return err
+	}
+	if sets1-sets0 != 3 || gets1-gets0 != 2 {
+		return fmt.Errorf("expected 3 set and 2 get calls, got %d and %d",
+			sets1-sets0, gets1-gets0)
+	}
+
+	// commands over http are counted too
+	res, err := http.Get(fmt.Sprintf("http://localhost:%d/GET+fleet+truck1", mc.port))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	gets2, err := calls("get")
+	if err != nil {
+		return err
+	}
+	if gets2-gets1 != 1 {
+		return fmt.Errorf("expected 1 get call over http, got %d", gets2-gets1)
+	}
+
+	// unknown commands are not
+	if err := mc.DoBatch([][]interface{}{
+		{"NOSUCHCMD"}, {"ERR unknown command 'NOSUCHCMD'"},
+		{"SCRIPT", "NOSUCHCMD"}, {"ERR unknown command 'SCRIPT NOSUCHCMD'"},
+	}); err != nil {
+		return err
+	}
+	for _, cmd := range []string{"nosuchcmd", "script nosuchcmd"} {
+		n, err := calls(cmd)
+		if err != nil {
+			return err
+		}
+		if n != 0 {
+			return fmt.Errorf("expected no %s calls, got %d", cmd, n)
+		}
+	}
+	return nil
+}
+
+func info_cpu_test(mc *mockServer) error {
+	res, err := mc.Do("INFO", "cpu")
+	if err != nil {
+		return err
+	}
+	bres, ok := res.([]byte)
+	if !ok {
+		return errors.New("Failed to type assert INFO response")
+	}
+	if !strings.HasPrefix(string(bres), "# CPU\r\n") ||
+		!strings.Contains(string(bres), "used_cpu_user:") {
+		return fmt.Errorf("expected the CPU section, got '%s'", bres)
+	}
+	if _, err := mc.Do("OUTPUT", "JSON"); err != nil {
+		return err
+	}
+	res, err = mc.Do("INFO", "cpu")
+	if err != nil {
+		return err
+	}
+	info := gjson.GetBytes(res.([]byte), "info")
+	for _, field := range []string{"used_cpu_sys", "used_cpu_user", "used_cpu_sys_children"} {
+		if info.Get(field).Type != gjson.Number {
+			return fmt.Errorf("expected a number for '%s', got '%s'", field, info)
+		}
+	}
+	return nil
+}
+
+func info_expired_keys_test(mc *mockServer) error {
+	if _, err := mc.Do("OUTPUT", "JSON"); err != nil {
+		return err
+	}
+	expired := func() (gjson.Result, error) {
+		res, err := mc.Do("SERVER", "EXT")
+		if err != nil {
+			return gjson.Result{}, err
+		}
+		bres, ok := res.([]byte)
+		if !ok {
+			return gjson.Result{}, errors.New("Failed to type assert SERVER EXT response")
+		}
+		return gjson.GetBytes(bres, "stats"), nil
+	}
+	stats0, err := expired()
+	if err != nil {
+		return err
+	}
+	for _, key := range []string{"expfleet1", "expfleet2"} {
+		if _, err := mc.Do("SET", key, "truck1", "EX", 0.1, "POINT", 33, -115); err != nil {
+			return err
+		}
+	}
+	// wait for the background sweep to purge both items
+	for start := time.Now(); time.Since(start) < 5*time.Second; {
+		time.Sleep(time.Second / 10)
+		stats1, err := expired()
+		if err != nil {
+			return err
+		}
+		n1 := stats1.Get("tile38_collection_expired_keys.expfleet1").Int() -
+			stats0.Get("tile38_collection_expired_keys.expfleet1").Int()
+		n2 := stats1.Get("tile38_collection_expired_keys.expfleet2").Int() -
+			stats0.Get("tile38_collection_expired_keys.expfleet2").Int()
+		total := stats1.Get("tile38_expired_keys").Int() -
+			stats0.Get("tile38_expired_keys").Int()
+		if n1 == 1 && n2 == 1 && total >= 2 {
+			return nil
+		}
+	}
+	return errors.New("expected an expiration in both collections")
+}
+
+func info_expired_keys_pruned_test(mc *mockServer) error {
+	if _, err := mc.Do("OUTPUT", "JSON"); err != nil {
+		return err
+	}
+	expired := func() (gjson.Result, error) {
+		res, err := mc.Do("SERVER", "EXT")
+		if err != nil {
+			return gjson.Result{}, err
+		}
+		bres, ok := res.([]byte)
+		if !ok {
+			return gjson.Result{}, errors.New("Failed to type assert SERVER EXT response")
+		}
+		return gjson.GetBytes(bres, "stats.tile38_collection_expired_keys"), nil
+	}
+	expect := func(expected string) error {
+		counts, err := expired()
+		if err != nil {
+			return err
+		}
+		if counts.Raw != expected {
+			return fmt.Errorf("expected '%s', got '%s'", expected, counts.Raw)
+		}
+		return nil
+	}
+	// keep a truck in each collection so they outlive the expired one
+	keys := []string{"prune1", "prune2", "prune3"}
+	for _, key := range keys {
+		if _, err := mc.Do("SET", key, "truck1", "POINT", 33, -115); err != nil {
+			return err
+		}
+		if _, err := mc.Do("SET", key, "truck2", "EX", 0.1, "POINT", 33, -115); err != nil {
+			return err
+		}
+	}
+	// wait for the background sweep to purge the expiring items
+	var counts gjson.Result
+	for start := time.Now(); time.Since(start) < 5*time.Second; {
+		time.Sleep(time.Second / 10)
+		var err error
+		if counts, err = expired(); err != nil {
+			return err
+		}
+		if len(counts.Map()) == len(keys) {
+			break
+		}
+	}
+	if err := expect(`{"prune1":1,"prune2":1,"prune3":1}`); err != nil {
+		return err
+	}
+
+	if _, err := mc.Do("DROP", "prune1"); err != nil {
+		return err
+	}
+	if err := expect(`{"prune2":1,"prune3":1}`); err != nil {
+		return err
+	}
+	if _, err := mc.Do("RENAME", "prune2", "prune4"); err != nil {
+		return err
+	}
+	if err := expect(`{"prune3":1,"prune4":1}`); err != nil {
+		return err
+	}
+	if _, err := mc.Do("FLUSHDB"); err != nil {
+		return err
+	}
+	return expect(`{}`)
+}
+
+func info_replication_test(mc *mockServer) error {
+	if err := mc.DoBatch([][]interface{}{
+		{"REPLCONF", "listening-port", 9851}, {"OK"},
+		{"REPLCONF", "ip-address", "10.0.0.5"}, {"OK"},
+	}); err != nil {
+		return err
+	}
+	res, err := mc.Do("INFO", "replication")
+	if err != nil {
+		return err
+	}
+	bres, ok := res.([]byte)
+	if !ok {
+		return errors.New("Failed to type assert INFO response")
+	}
+	line := "slave0:ip=10.0.0.5,port=9851,state=online\r\n"
+	if !strings.Contains(string(bres), line) {
+		return fmt.Errorf("expected '%s' in INFO replication, got '%s'",
+			strings.TrimSpace(line), bres)
+	}
+	return nil
+}
+
+func info_aof_buffer_test(mc *mockServer) error {
+	// The AOF buffer is flushed before each reply is written, so it's only
+	// observable from within a script that has already queued a write.
+	script := `
+		local function stat(name)
+			local stats = tile38.call('server', 'ext')
+			for i = 1, #stats, 2 do
+				if stats[i] == name then
+					return tonumber(stats[i + 1])
+				end
+			end
+		end
+		local before = stat('tile38_aof_buffer_bytes')
+		tile38.call('set', KEYS[1], 'myid', 'point', 33, -115)
+		return {before, stat('tile38_aof_buffer_bytes') > before}
+	`
+	return mc.DoBatch([][]interface{}{
+		{"EVAL", script, 1, "mykey"}, {"[0 1]"},
+	})
+}
+
+func info_active_followers_test(mc *mockServer) error {
+	followers := func() (active, connected int64, err error) {
+		res, err := mc.Do("SERVER", "EXT")
+		if err != nil {
+			return 0, 0, err
+		}
+		bres, ok := res.([]byte)
+		if !ok {
+			return 0, 0, errors.New("Failed to type assert SERVER EXT response")
+		}
+		stats := gjson.GetBytes(bres, "stats")
+		return stats.Get("tile38_active_followers").Int(),
+			stats.Get("tile38_connected_slaves").Int(), nil
+	}
+	// waitFor polls until the follower stats match, since the server
+	// notices closed connections asynchronously
+	waitFor := func(active, connected int64) error {
+		var a, c int64
+		var err error
+		for i := 0; i < 100; i++ {
+			if a, c, err = followers(); err != nil {
+				return err
+			}
+			if a == active && c == connected {
+				return nil
+			}
+			time.Sleep(time.Millisecond * 10)
+		}
+		return fmt.Errorf("expected %d active and %d connected followers, got %d and %d",
+			active, connected, a, c)
+	}
+	if _, err := mc.Do("OUTPUT", "JSON"); err != nil {
+		return err
+	}
+	if err := waitFor(0, 0); err != nil {
+		return err
+	}
+
+	// act like a follower: announce a port, then stream the AOF
+	conn, err := redis.Dial("tcp", fmt.Sprintf(":%d", mc.port))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.Do("REPLCONF", "listening-port", 9852); err != nil {
+		return err
+	}
+	if err := conn.Send("AOF", 0); err != nil {
+		return err
+	}
+	if err := conn.Flush(); err != nil {
+		return err
+	}
+	if err := waitFor(1, 1); err != nil {
+		return err
+	}
+	conn.Close()
+	return waitFor(0, 0)
+}
+
+func info_memstats_poller_test(mc *mockServer) error {
+	if err := mc.DoBatch([][]interface{}{
+		{"CONFIG", "GET", "memstats-poller"}, {"[memstats-poller yes]"},
+		{"CONFIG", "SET", "memstats-poller", "maybe"}, {"ERR Invalid argument 'maybe' for CONFIG SET 'memstats-poller'"},
+		{"CONFIG", "SET", "memstats-poller", "no"}, {"OK"},
+		{"CONFIG", "GET", "memstats-poller"}, {"[memstats-poller no]"},
+	}); err != nil {
+		return err
+	}
+	// memstats are read on demand while the poller is disabled
+	if _, err := mc.Do("OUTPUT", "JSON"); err != nil {
+		return err
+	}
+	res, err := mc.Do("SERVER")
+	if err != nil {
+		return err
+	}
+	bres, ok := res.([]byte)
+	if !ok {
+		return errors.New("Failed to type assert SERVER response")
+	}
+	stats := gjson.GetBytes(bres, "stats")
+	if alloc, heap := stats.Get("mem_alloc"), stats.Get("heap_size"); alloc.Int() <= 0 || heap.Int() <= 0 {
+		return fmt.Errorf("expected memory stats, got mem_alloc '%s' and heap_size '%s'",
+			alloc.Raw, heap.Raw)
+	}
+	if _, err := mc.Do("OUTPUT", "RESP"); err != nil {
+		return err
+	}
+	return mc.DoBatch([][]interface{}{
+		{"CONFIG", "SET", "memstats-poller", "yes"}, {"OK"},
+	})
+}
@@ -13,7 +13,9 @@ import (
 func subTestTimeout(t *testing.T, mc *mockServer) {
 	runStep(t, mc, "spatial", timeout_spatial_test)
 	runStep(t, mc, "search", timeout_search_test)
+	runStep(t, mc, "default scan timeout", timeout_default_scan_test)
 	runStep(t, mc, "scripts", timeout_scripts_test)
+	runStep(t, mc, "deadline remaining in scripts", timeout_deadline_remaining_test)
 	runStep(t, mc, "no writes", timeout_no_writes_test)
 	runStep(t, mc, "within scripts", timeout_within_scripts_test)
 	runStep(t, mc, "no writes within scripts", timeout_no_writes_within_scripts_test)
@@ -77,6 +79,23 @@ func timeout_search_test(mc *mockServer) (err error) {
 	})
 }
 
+func timeout_default_scan_test(mc *mockServer) (err error) {
+	err = setup(mc, 10000, true)
+
+	return mc.DoBatch([][]interface{}{
+		{"CONFIG", "SET", "default-scan-timeout", "-1"}, {"ERR Invalid argument '-1' for CONFIG SET 'default-scan-timeout'"},
+		{"CONFIG", "SET", "default-scan-timeout", "0.000001"}, {"OK"},
+		{"CONFIG", "GET", "default-scan-timeout"}, {"[default-scan-timeout 0.000001]"},
+
+		{"SCAN", "mykey", "WHERE", "foo", -1, 2, "COUNT"}, {"ERR timeout"},
+		{"WITHIN", "mykey", "WHERE", "foo", -1, 2, "COUNT", "BOUNDS", -90, -180, 90, 180}, {"ERR timeout"},
+		{"TIMEOUT", "10", "SCAN", "mykey", "WHERE", "foo", -1, 2, "COUNT"}, {"10000"},
+
+		{"CONFIG", "SET", "default-scan-timeout", "0"}, {"OK"},
+		{"SCAN", "mykey", "WHERE", "foo", -1, 2, "COUNT"}, {"10000"},
+	})
+}
+
 func timeout_scripts_test(mc *mockServer) (err error) {
 	script := `
 		local clock = os.clock
@@ -95,9 +114,9 @@ func timeout_scripts_test(mc *mockServer) (err error) {
 		{"EVALROSHA", sha, 0}, {nil},
 		{"EVALNASHA", sha, 0}, {nil},
 
-		{"TIMEOUT", "0.1", "EVALSHA", sha, 0}, {"ERR timeout"},
-		{"TIMEOUT", "0.1", "EVALROSHA", sha, 0}, {"ERR timeout"},
-		{"TIMEOUT", "0.1", "EVALNASHA", sha, 0}, {"ERR timeout"},
+		{"TIMEOUT", "0.1", "EVALSHA", sha, 0}, {"ERR script timeout"},
+		{"TIMEOUT", "0.1", "EVALROSHA", sha, 0}, {"ERR script timeout"},
+		{"TIMEOUT", "0.1", "EVALNASHA", sha, 0}, {"ERR script timeout"},
 
 		{"TIMEOUT", "0.9", "EVALSHA", sha, 0}, {nil},
 		{"TIMEOUT", "0.9", "EVALROSHA", sha, 0}, {nil},
@@ -105,6 +124,27 @@ func timeout_scripts_test(mc *mockServer) (err error) {
 	})
 }
 
+func timeout_deadline_remaining_test(mc *mockServer) (err error) {
+	script := `
+		local clock = os.clock
+		local function sleep(n)
+			local t0 = clock()
+			while clock() - t0 <= n do end
+		end
+		local before = tile38.deadline_remaining()
+		sleep(0.1)
+		local after = tile38.deadline_remaining()
+		return {before <= 10, after < before, after > 0}
+	`
+
+	return mc.DoBatch([][]interface{}{
+		{"TIMEOUT", "10", "EVAL", script, 0}, {"[1 1 1]"},
+		{"TIMEOUT", "10", "EVALRO", script, 0}, {"[1 1 1]"},
+		{"TIMEOUT", "10", "EVALNA", script, 0}, {"[1 1 1]"},
+		{"EVAL", "return tile38.deadline_remaining()", 0}, {"2147483647"},
+	})
+}
+
 func timeout_no_writes_test(mc *mockServer) (err error) {
 	return mc.DoBatch([][]interface{}{
 		{"SET", "mykey", "myid", "STRING", "foo"}, {"OK"},